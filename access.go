@@ -0,0 +1,300 @@
+/*
+access.go controls who may connect and log in.
+
+Connections are limited globally, and per IP. Sites can be banned by CIDR range, with a reason and an optional expiry,
+which are stored in the site_bans table. Bans and limits are checked before the welcome banner is written.
+
+Failed passwords lock the IP out of logging in, for a time which doubles with each failure.
+*/
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxConnections      = 1000
+	defaultMaxConnectionsPerIP = 10
+)
+
+// remoteIP returns the IP the connection is from, without the port.
+func remoteIP(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// admitSession checks the session against the connection limits. The session must already be open, so it's counted.
+// It returns the message to send the client if they're refused, or an empty string if they're admitted.
+func admitSession(s *Session, config Config) string {
+	total, fromIP := sessionCounts(s.IP())
+	if config.MaxConnections > 0 && total > config.MaxConnections {
+		return "The world is full. Please try again later.\r\n"
+	}
+	if config.MaxConnectionsPerIP > 0 && fromIP > config.MaxConnectionsPerIP {
+		return "There are too many connections from your address.\r\n"
+	}
+	return ""
+}
+
+//
+// site bans
+//
+
+type SiteBan struct {
+	Network  *net.IPNet
+	Reason   string
+	Expires  time.Time // zero for a permanent ban
+	BannedBy string
+}
+
+func (b SiteBan) Expired(now time.Time) bool {
+	return !b.Expires.IsZero() && now.After(b.Expires)
+}
+
+var siteBans = struct {
+	sync.Mutex
+	bans map[string]SiteBan // CIDR to ban
+}{bans: make(map[string]SiteBan)}
+
+// parseSiteBanNetwork parses a CIDR range. A single IP bans just that IP.
+func parseSiteBanNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP '%s'", s)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+// parseBanDuration parses a ban duration, such as 30m, 12h or 7d. perm or permanent is a permanent ban, and returns 0.
+func parseBanDuration(s string) (time.Duration, bool) {
+	s = strings.ToLower(s)
+	if s == "perm" || s == "permanent" {
+		return 0, true
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 1 {
+			return 0, false
+		}
+		return time.Duration(days) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+func loadSiteBans(db *sql.DB) {
+	rows, err := db.Query(`select cidr, reason, expires, banned_by from site_bans;`)
+	if err != nil {
+		fmt.Print("dberr loadSiteBans ")
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	siteBans.Lock()
+	defer siteBans.Unlock()
+	for rows.Next() {
+		var cidr string
+		var ban SiteBan
+		var expires int64
+		rows.Scan(&cidr, &ban.Reason, &expires, &ban.BannedBy)
+		network, err := parseSiteBanNetwork(cidr)
+		if err != nil {
+			fmt.Println("loadSiteBans error: " + err.Error())
+			continue
+		}
+		ban.Network = network
+		if expires != 0 {
+			ban.Expires = time.Unix(expires, 0)
+		}
+		siteBans.bans[network.String()] = ban
+	}
+}
+
+// siteBanned returns the ban covering the IP, if there is one.
+func siteBanned(ip string) (SiteBan, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return SiteBan{}, false
+	}
+	now := time.Now()
+	siteBans.Lock()
+	defer siteBans.Unlock()
+	for _, ban := range siteBans.bans {
+		if !ban.Expired(now) && ban.Network.Contains(parsed) {
+			return ban, true
+		}
+	}
+	return SiteBan{}, false
+}
+
+// SiteBans returns the bans which haven't expired, ordered by range.
+func SiteBans() []SiteBan {
+	now := time.Now()
+	siteBans.Lock()
+	defer siteBans.Unlock()
+	var bans []SiteBan
+	for _, ban := range siteBans.bans {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Network.String() < bans[j].Network.String() })
+	return bans
+}
+
+func saveSiteBan(db *sql.DB, ban SiteBan) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	var expires int64
+	if !ban.Expires.IsZero() {
+		expires = ban.Expires.Unix()
+	}
+	if _, err := tx.Exec(`insert or replace into site_bans (cidr, reason, expires, banned_by) values (?,?,?,?);`, ban.Network.String(), ban.Reason, expires, ban.BannedBy); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+func deleteSiteBan(db *sql.DB, cidr string) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`delete from site_bans where cidr = ?;`, cidr); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// addSiteBan bans the range, replacing any existing ban of the same range, and disconnects anyone connected from it.
+func addSiteBan(world *World, ban SiteBan) {
+	siteBans.Lock()
+	siteBans.bans[ban.Network.String()] = ban
+	siteBans.Unlock()
+	saveSiteBan(world.db, ban)
+
+	for _, s := range Sessions() {
+		if ip := net.ParseIP(s.IP()); ip != nil && ban.Network.Contains(ip) {
+			s.Write([]byte("\r\nYour site has been banned.\r\n"))
+			s.Close()
+		}
+	}
+}
+
+// removeSiteBan lifts the ban on the range. It returns false if the range wasn't banned.
+func removeSiteBan(world *World, network *net.IPNet) bool {
+	cidr := network.String()
+	siteBans.Lock()
+	_, ok := siteBans.bans[cidr]
+	delete(siteBans.bans, cidr)
+	siteBans.Unlock()
+	if ok {
+		deleteSiteBan(world.db, cidr)
+	}
+	return ok
+}
+
+//
+// login throttling
+//
+
+const (
+	loginFailuresAllowed = 3 // failures before the first lockout
+	loginLockoutBase     = 30 * time.Second
+	loginLockoutMax      = time.Hour
+	loginFailureMemory   = time.Hour // failures are forgotten after this long without another
+)
+
+type loginFailure struct {
+	count int
+	last  time.Time
+	until time.Time // locked out until
+}
+
+var loginFailures = struct {
+	sync.Mutex
+	byIP map[string]*loginFailure
+}{byIP: make(map[string]*loginFailure)}
+
+// loginLockedOut returns how much longer the IP is locked out of logging in, if it is.
+func loginLockedOut(ip string) (time.Duration, bool) {
+	now := time.Now()
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	failure, ok := loginFailures.byIP[ip]
+	if !ok {
+		return 0, false
+	}
+	if now.Sub(failure.last) > loginFailureMemory {
+		delete(loginFailures.byIP, ip)
+		return 0, false
+	}
+	if now.Before(failure.until) {
+		return failure.until.Sub(now), true
+	}
+	return 0, false
+}
+
+// loginFailed records a failed password from the IP, locking it out if it's failed too often.
+func loginFailed(ip string) {
+	now := time.Now()
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	failure, ok := loginFailures.byIP[ip]
+	if !ok || now.Sub(failure.last) > loginFailureMemory {
+		failure = &loginFailure{}
+		loginFailures.byIP[ip] = failure
+	}
+	failure.count++
+	failure.last = now
+	if failure.count < loginFailuresAllowed {
+		return
+	}
+	lockout := loginLockoutBase
+	for i := loginFailuresAllowed; i < failure.count && lockout < loginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > loginLockoutMax {
+		lockout = loginLockoutMax
+	}
+	failure.until = now.Add(lockout)
+	fmt.Println("login lockout for " + ip + " for " + lockout.String())
+}
+
+// loginSucceeded forgets the IP's failures.
+func loginSucceeded(ip string) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	delete(loginFailures.byIP, ip)
+}
@@ -0,0 +1,355 @@
+/*
+accounts.go contains the Account type, which is what a person logs in with.
+
+An account has a login, a password, and settings, and owns any number of player characters.
+After logging in, the person chooses which of their characters to play, or creates a new one.
+
+Accounts aren't Things: they're only needed while logging in, and by a few commands,
+so they're read from and written to the accounts table directly, rather than kept in a manager.
+*/
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Account struct {
+	id          identifier
+	login       string
+	passthesalt []byte
+	pass        []byte
+	scrypt      ScryptParams // the parameters pass was hashed with
+	resetToken  string       // the hash of the one-time token the account must log in with, if an admin reset its password
+	Settings    map[string]string
+	Created     time.Time
+	LastLogin   time.Time
+}
+
+// account settings
+const (
+	settingLastCharacter = "last_character" // the character played last, which the character menu selects by default
+)
+
+func (a *Account) Id() identifier {
+	return a.id
+}
+
+func (a *Account) Login() string {
+	return a.login
+}
+
+// accountCreation serialises creating accounts, so two connections can't create the same login.
+var accountCreation sync.Mutex
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+const accountColumns = `id, login, salt, pass, scrypt_n, scrypt_r, scrypt_p, reset_token, settings, created, last_login`
+
+func scanAccount(row *sql.Row) (*Account, error) {
+	var account Account
+	var salt, pass, settings string
+	var created, lastLogin int64
+	err := row.Scan(&account.id, &account.login, &salt, &pass, &account.scrypt.N, &account.scrypt.R, &account.scrypt.P, &account.resetToken, &settings, &created, &lastLogin)
+	if err != nil {
+		return nil, err
+	}
+	account.passthesalt = []byte(salt)
+	account.pass = []byte(pass)
+	account.Created = timeOrZero(created)
+	account.LastLogin = timeOrZero(lastLogin)
+	account.Settings = make(map[string]string)
+	if settings != "" {
+		if err := json.Unmarshal([]byte(settings), &account.Settings); err != nil {
+			fmt.Println("account settings error: " + account.login + " " + err.Error())
+		}
+	}
+	return &account, nil
+}
+
+// loadAccount loads the account with the login from the database.
+func loadAccount(db *sql.DB, login string) (*Account, bool) {
+	if db == nil {
+		return nil, false
+	}
+	account, err := scanAccount(db.QueryRow(`select `+accountColumns+` from accounts where login = ?;`, login))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Print("dberr loadAccount ")
+			fmt.Println(err)
+		}
+		return nil, false
+	}
+	return account, true
+}
+
+func loadAccountById(db *sql.DB, id identifier) (*Account, bool) {
+	if db == nil {
+		return nil, false
+	}
+	account, err := scanAccount(db.QueryRow(`select `+accountColumns+` from accounts where id = ?;`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Print("dberr loadAccountById ")
+			fmt.Println(err)
+		}
+		return nil, false
+	}
+	return account, true
+}
+
+// findAccount loads the account with the login, or else the account which owns the character with the name.
+func findAccount(db *sql.DB, name string) (*Account, bool) {
+	if account, ok := loadAccount(db, name); ok {
+		return account, true
+	}
+	if db == nil {
+		return nil, false
+	}
+	var id identifier
+	if err := db.QueryRow(`select account_id from players where name = ?;`, name).Scan(&id); err != nil {
+		return nil, false
+	}
+	return loadAccountById(db, id)
+}
+
+// insertAccount writes a new account to the database. The write is committed asynchronously.
+func insertAccount(db *sql.DB, account *Account) {
+	settings, err := json.Marshal(account.Settings)
+	if err != nil {
+		fmt.Println("insertAccount error: " + err.Error())
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`insert into accounts (`+accountColumns+`) values (?,?,?,?,?,?,?,?,?,?,?);`,
+		account.id, account.login, string(account.passthesalt), string(account.pass), account.scrypt.N, account.scrypt.R, account.scrypt.P,
+		account.resetToken, string(settings), unixOrZero(account.Created), unixOrZero(account.LastLogin)); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// saveAccount writes the account's settings and last login to the database. It may be called from anywhere; the write is committed asynchronously.
+// It doesn't write the password, so it can't undo a password change made by another connection to the account; see saveAccountPassword.
+func saveAccount(db *sql.DB, account *Account) {
+	if db == nil {
+		return
+	}
+	settings, err := json.Marshal(account.Settings)
+	if err != nil {
+		fmt.Println("saveAccount error: " + err.Error())
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`update accounts set settings = ?, last_login = ? where id = ?;`, string(settings), unixOrZero(account.LastLogin), account.id); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// saveAccountPassword writes the account's password hash and reset token to the database. The write is committed asynchronously.
+func saveAccountPassword(db *sql.DB, account *Account) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`update accounts set salt = ?, pass = ?, scrypt_n = ?, scrypt_r = ?, scrypt_p = ?, reset_token = ? where id = ?;`,
+		string(account.passthesalt), string(account.pass), account.scrypt.N, account.scrypt.R, account.scrypt.P, account.resetToken, account.id); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// createAccount creates an account with the login and password. It returns false if the login is taken.
+// It waits for the account to be committed, so the login can't be taken twice.
+func createAccount(world *World, login string, pass []byte) (*Account, bool, error) {
+	if world.db == nil {
+		return nil, false, fmt.Errorf("no database")
+	}
+	accountCreation.Lock()
+	defer accountCreation.Unlock()
+	if _, exists := loadAccount(world.db, login); exists {
+		return nil, false, nil
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return nil, false, err
+	}
+	hashedPass, err := hashPassword(pass, salt, currentScryptParams)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	account := &Account{
+		id:          <-NextId,
+		login:       login,
+		passthesalt: salt,
+		pass:        hashedPass,
+		scrypt:      currentScryptParams,
+		Settings:    make(map[string]string),
+		Created:     now,
+		LastLogin:   now,
+	}
+	insertAccount(world.db, account)
+	done := make(chan bool)
+	flushCommits <- done
+	<-done
+	return account, true, nil
+}
+
+// accountCharacters returns the names of the account's characters, in the order they were created.
+func accountCharacters(world *World, account *Account) []string {
+	if world.db == nil {
+		return nil
+	}
+	rows, err := world.db.Query(`select name from players where account_id = ? order by id;`, account.id)
+	if err != nil {
+		fmt.Print("dberr accountCharacters ")
+		fmt.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	return names
+}
+
+// accountRole returns the highest role of the account's characters.
+// Characters in the world may have been granted roles which aren't saved yet, so their roles are read from the world.
+func accountRole(world *World, account *Account) Role {
+	if world.db == nil {
+		return rolePlayer
+	}
+	rows, err := world.db.Query(`select name, role from players where account_id = ?;`, account.id)
+	if err != nil {
+		fmt.Print("dberr accountRole ")
+		fmt.Println(err)
+		return roleOwner // so nobody can act on the account, rather than everybody
+	}
+	defer rows.Close()
+	highest := rolePlayer
+	for rows.Next() {
+		var name string
+		var role Role
+		if err := rows.Scan(&name, &role); err != nil {
+			fmt.Print("dberr accountRole ")
+			fmt.Println(err)
+			return roleOwner
+		}
+		if player, exists := world.players.GetByName(name); exists {
+			role = player.Role()
+		}
+		if role > highest {
+			highest = role
+		}
+	}
+	return highest
+}
+
+// characterExists returns whether a character with the name exists, in the world or the database.
+func characterExists(world *World, name string) bool {
+	if _, exists := world.players.GetByName(name); exists {
+		return true
+	}
+	if world.db == nil {
+		return false
+	}
+	var count int
+	if err := world.db.QueryRow(`select count(*) from players where name = ?;`, name).Scan(&count); err != nil {
+		fmt.Print("dberr characterExists ")
+		fmt.Println(err)
+		return true
+	}
+	return count > 0
+}
+
+// migrateAccounts gives every player from before accounts existed an account of their own, with the player's name as
+// the login, and the player's password. It's run at startup, after setNextId, and before anything else writes.
+func migrateAccounts(db *sql.DB) {
+	rows, err := db.Query(`select id, name, salt, pass, scrypt_n, scrypt_r, scrypt_p, reset_token from players where account_id is null or account_id = 0;`)
+	if err != nil {
+		fmt.Print("dberr migrateAccounts ")
+		fmt.Println(err)
+		return
+	}
+	type legacyPlayer struct {
+		id      identifier
+		account Account
+	}
+	var players []legacyPlayer
+	for rows.Next() {
+		var p legacyPlayer
+		var salt, pass string
+		rows.Scan(&p.id, &p.account.login, &salt, &pass, &p.account.scrypt.N, &p.account.scrypt.R, &p.account.scrypt.P, &p.account.resetToken)
+		p.account.passthesalt = []byte(salt)
+		p.account.pass = []byte(pass)
+		players = append(players, p)
+	}
+	rows.Close()
+	if len(players) == 0 {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Print("dberr migrateAccounts ")
+		fmt.Println(err)
+		return
+	}
+	now := time.Now().Unix()
+	for _, p := range players {
+		accountId := <-NextId
+		if _, err := tx.Exec(`insert into accounts (`+accountColumns+`) values (?,?,?,?,?,?,?,?,?,?,?);`,
+			accountId, p.account.login, string(p.account.passthesalt), string(p.account.pass), p.account.scrypt.N, p.account.scrypt.R, p.account.scrypt.P,
+			p.account.resetToken, `{}`, now, 0); err != nil {
+			fmt.Print("dberr migrateAccounts ")
+			fmt.Println(err)
+			tx.Rollback()
+			return
+		}
+		if _, err := tx.Exec(`update players set account_id = ?, salt = '', pass = '', reset_token = '' where id = ?;`, accountId, p.id); err != nil {
+			fmt.Print("dberr migrateAccounts ")
+			fmt.Println(err)
+			tx.Rollback()
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Print("dberr migrateAccounts ")
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("migrated " + strconv.Itoa(len(players)) + " players to accounts")
+}
@@ -0,0 +1,171 @@
+/*
+aliases.go contains players' command aliases.
+
+An alias is a word which the parser replaces with its expansion before looking up commands,
+e.g. 'alias add kk get sword;drop shield'. Expansions may contain several commands, and other aliases.
+
+In an expansion, $1 to $9 are replaced with the alias's arguments, and $* with all of them.
+If the expansion has none of these, the arguments are appended to it, so 'alias add k kill' makes 'k bob' 'kill bob'.
+
+Aliases can't hide protected commands, such as quit and password, so players can't lock themselves out of them.
+*/
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxAliases         = 50  // the most aliases a player may have
+	maxAliasExpansion  = 512 // the longest an expansion may be
+	maxAliasDepth      = 10  // how deeply aliases may expand other aliases
+	maxAliasedCommands = 50  // the most commands a line may expand to
+)
+
+var errAliasDepth = errors.New("That alias expands other aliases too deeply.")
+var errAliasCommands = errors.New("That alias expands to too many commands.")
+
+const validAliasRegex = "^[a-z0-9]+$"
+
+// expandAlias substitutes the arguments into the alias's expansion.
+func expandAlias(expansion string, argString string) string {
+	argString = strings.TrimSpace(argString)
+	args := splitArgs(argString)
+	substituted := false
+	var s strings.Builder
+	for i := 0; i < len(expansion); i++ {
+		if expansion[i] != '$' || i+1 == len(expansion) {
+			s.WriteByte(expansion[i])
+			continue
+		}
+		next := expansion[i+1]
+		switch {
+		case next == '*':
+			s.WriteString(argString)
+			substituted = true
+			i++
+		case next >= '1' && next <= '9':
+			if n := int(next - '1'); n < len(args) {
+				s.WriteString(args[n])
+			}
+			substituted = true
+			i++
+		case next == '$':
+			s.WriteByte('$')
+			i++
+		default:
+			s.WriteByte('$')
+		}
+	}
+	if !substituted && argString != "" {
+		s.WriteString(" " + argString)
+	}
+	return s.String()
+}
+
+// aliasProtected returns whether the name is the name or alias of a protected command.
+func aliasProtected(name string) bool {
+	for _, command := range commands {
+		if !command.Protected {
+			continue
+		}
+		if command.Name == name {
+			return true
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// alias adds, removes and lists the player's aliases.
+func alias(args []string, playerId identifier, world *World) {
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		listAliases(playerId, world)
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) < 3 {
+			tryPlayerWrite(playerId, world.players, "Usage: alias add name expansion", "alias called with invalid player")
+			return
+		}
+		addAlias(strings.ToLower(args[1]), strings.Join(args[2:], " "), playerId, world)
+	case "remove":
+		if len(args) < 2 {
+			tryPlayerWrite(playerId, world.players, "Usage: alias remove name", "alias called with invalid player")
+			return
+		}
+		removeAlias(strings.ToLower(args[1]), playerId, world)
+	default:
+		tryPlayerWrite(playerId, world.players, "Usage: alias [add name expansion/remove name/list]", "alias called with invalid player")
+	}
+}
+
+func addAlias(name string, expansion string, playerId identifier, world *World) {
+	if len(expansion) > 1 && strings.HasPrefix(expansion, "\"") && strings.HasSuffix(expansion, "\"") {
+		expansion = expansion[1 : len(expansion)-1]
+	}
+	if valid, err := regexp.MatchString(validAliasRegex, name); err != nil || !valid {
+		tryPlayerWrite(playerId, world.players, "Alias names can only contain letters and digits.", "alias called with invalid player")
+		return
+	}
+	if aliasProtected(name) {
+		tryPlayerWrite(playerId, world.players, "You can't alias '"+name+"'.", "alias called with invalid player")
+		return
+	}
+	if len(expansion) > maxAliasExpansion {
+		tryPlayerWrite(playerId, world.players, "Aliases can be at most "+strconv.Itoa(maxAliasExpansion)+" characters.", "alias called with invalid player")
+		return
+	}
+	world.players.ChangeById(playerId, func(p *Player) {
+		if p.Aliases == nil {
+			p.Aliases = make(map[string]string)
+		}
+		if _, exists := p.Aliases[name]; !exists && len(p.Aliases) >= maxAliases {
+			p.Write("You can have at most " + strconv.Itoa(maxAliases) + " aliases.")
+			return
+		}
+		p.Aliases[name] = expansion
+		p.Write("'" + name + "' is now an alias for '" + expansion + "'.")
+	})
+}
+
+func removeAlias(name string, playerId identifier, world *World) {
+	world.players.ChangeById(playerId, func(p *Player) {
+		if _, exists := p.Aliases[name]; !exists {
+			p.Write("You have no alias '" + name + "'.")
+			return
+		}
+		delete(p.Aliases, name)
+		p.Write("'" + name + "' is no longer an alias.")
+	})
+}
+
+func listAliases(playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		return
+	}
+	if len(player.Aliases) == 0 {
+		player.Write("You have no aliases.")
+		return
+	}
+	names := make([]string, 0, len(player.Aliases))
+	for name := range player.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := "Your aliases:"
+	for _, name := range names {
+		s += "\r\n" + name + "\t" + player.Aliases[name]
+	}
+	player.Write(s)
+}
@@ -0,0 +1,33 @@
+/*
+aliases_test.go tests substituting arguments into alias expansions.
+*/
+package main
+
+import (
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	tests := []struct {
+		expansion string
+		args      string
+		want      string
+	}{
+		{"kill", "", "kill"},
+		{"kill", "bob", "kill bob"},
+		{"kill $1", "bob fred", "kill bob"},
+		{"give $2 $1", `"long sword" bob`, "give bob long sword"},
+		{"kill $3", "bob", "kill "},
+		{"say $*", "  hello there  ", "say hello there"},
+		{"say $* $1", "hi", "say hi hi"},
+		{"say $$1", "", "say $1"},
+		{"say $$1", "bob", "say $1 bob"},
+		{"say $x", "", "say $x"},
+		{"say $", "", "say $"},
+	}
+	for _, test := range tests {
+		if got := expandAlias(test.expansion, test.args); got != test.want {
+			t.Errorf("expandAlias(%q, %q) = %q, want %q", test.expansion, test.args, got, test.want)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 /*
 ANSI color related types and functions
 
+It also wraps text to the player's screen width, without counting color codes as visible text.
+
 */
 package main
 
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
 type colorcode string
 
 const (
@@ -25,3 +33,116 @@ const (
 	White     = "\x1b[0;30m"
 	Reset     = "\x1b[0m"
 )
+
+// colorNames are the colors admins can give things such as channels, by name.
+var colorNames = map[string]string{
+	"darkred":   Darkred,
+	"darkgreen": Darkgreen,
+	"brown":     Brown,
+	"darkblue":  Darkblue,
+	"darkpink":  Darkpink,
+	"darkcyan":  Darkcyan,
+	"grey":      Grey,
+	"darkgrey":  Darkgrey,
+	"red":       Red,
+	"green":     Green,
+	"yellow":    Yellow,
+	"blue":      Blue,
+	"pink":      Pink,
+	"cyan":      Cyan,
+}
+
+// defaultWrapWidth is the width output is wrapped to, when the client hasn't told us its window size.
+const defaultWrapWidth = 80
+
+const tabWidth = 8
+
+// ansiCodeLen returns the length of the ANSI escape code at the start of s, or 0 if s doesn't start with one.
+func ansiCodeLen(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// advance returns the column after writing s starting at col. ANSI escape codes take no space.
+func advance(col int, s string) int {
+	for i := 0; i < len(s); {
+		if n := ansiCodeLen(s[i:]); n > 0 {
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '\t' {
+			col += tabWidth - col%tabWidth
+		} else {
+			col++
+		}
+		i += size
+	}
+	return col
+}
+
+// WordWrap wraps s so no line is more than width visible characters, breaking between words where possible.
+// ANSI escape codes are never counted as visible characters, or split.
+// Existing line breaks are kept. A width of 0 or less returns s unchanged.
+func WordWrap(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	var buffer bytes.Buffer
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		cr := strings.HasSuffix(line, "\r")
+		wrapLine(&buffer, strings.TrimSuffix(line, "\r"), width)
+		if cr {
+			buffer.WriteString("\r")
+		}
+	}
+	return buffer.String()
+}
+
+// wrapLine writes a single line to buffer, wrapped at width.
+func wrapLine(buffer *bytes.Buffer, line string, width int) {
+	col := 0
+	for i, word := range strings.Split(line, " ") {
+		if i > 0 {
+			if col > 0 && advance(col+1, word) > width {
+				buffer.WriteString(endl)
+				col = 0
+			} else {
+				buffer.WriteString(" ")
+				col++
+			}
+		}
+		if advance(col, word) <= width {
+			buffer.WriteString(word)
+			col = advance(col, word)
+			continue
+		}
+		// the word is longer than a line; it has to be broken
+		for j := 0; j < len(word); {
+			if n := ansiCodeLen(word[j:]); n > 0 {
+				buffer.WriteString(word[j : j+n])
+				j += n
+				continue
+			}
+			_, size := utf8.DecodeRuneInString(word[j:])
+			char := word[j : j+size]
+			if col > 0 && advance(col, char) > width {
+				buffer.WriteString(endl)
+				col = 0
+			}
+			buffer.WriteString(char)
+			col = advance(col, char)
+			j += size
+		}
+	}
+}
@@ -0,0 +1,489 @@
+/*
+channels.go contains chat channels, which players talk on from anywhere in the world.
+
+Players talk on a channel by typing its name, e.g. 'ooc hello'. The built-in channels are ooc, newbie, builder and admin,
+and admins can create more with 'channel create'. Channels may need a role, so e.g. only admins can use the admin channel.
+
+Players are on every channel they can use, until they leave it. Admins can mute players on a channel, so they can't talk on it.
+Players' memberships are stored with them, in the player_channels table. Admin-created channels are stored in the channels table.
+
+Each channel remembers its last channelHistorySize messages, which 'history' replays, e.g. to players who just joined.
+New characters are on channels without joining them, so they're shown the history of each when they first enter the world.
+Channel names, like socials', can't be the start of a command's name, or they'd hide the command.
+*/
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// channelHistorySize is how many messages each channel remembers.
+const channelHistorySize = 20
+
+const validChannelRegex = "^[a-z]+$"
+
+// Channel is a chat channel.
+type Channel struct {
+	Name    string
+	Role    Role   // the role players need to use the channel
+	Color   string // the name of the channel's color. See colorNames
+	Builtin bool   // built-in channels can't be removed
+	history []channelMessage
+}
+
+type channelMessage struct {
+	at   time.Time
+	text string
+}
+
+// ChannelMembership is whether a player is on a channel. Players are on channels they have no membership for.
+type ChannelMembership struct {
+	Joined bool
+	Muted  bool // the player can't talk on the channel
+}
+
+func builtinChannels() map[string]*Channel {
+	return map[string]*Channel{
+		"ooc":     {Name: "ooc", Role: rolePlayer, Color: "yellow", Builtin: true},
+		"newbie":  {Name: "newbie", Role: rolePlayer, Color: "green", Builtin: true},
+		"builder": {Name: "builder", Role: roleBuilder, Color: "cyan", Builtin: true},
+		"admin":   {Name: "admin", Role: roleAdmin, Color: "red", Builtin: true},
+	}
+}
+
+var channels = struct {
+	sync.RWMutex
+	all map[string]*Channel
+}{all: builtinChannels()}
+
+// getChannel returns the channel, if it exists and a player with the role may use it.
+func getChannel(name string, role Role) (*Channel, bool) {
+	channels.RLock()
+	defer channels.RUnlock()
+	channel, ok := channels.all[name]
+	if !ok || role < channel.Role {
+		return nil, false
+	}
+	return channel, true
+}
+
+// channelNames returns the names of the channels a player with the role may use.
+func channelNames(role Role) []string {
+	channels.RLock()
+	defer channels.RUnlock()
+	var names []string
+	for name, channel := range channels.all {
+		if role >= channel.Role {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// channelCommand returns a command which talks on the channel with the name, for the parser.
+func channelCommand(name string, role Role) *Command {
+	channel, ok := getChannel(name, role)
+	if !ok {
+		return nil
+	}
+	return &Command{
+		Name:    name,
+		MinArgs: 1,
+		Usage:   "message",
+		Role:    channel.Role,
+		Raw:     true,
+		Func: func(args []string, playerId identifier, world *World) {
+			channelSay(name, strings.Join(args, " "), playerId, world)
+		},
+	}
+}
+
+// OnChannel returns whether the player is on the channel.
+func (p *Player) OnChannel(name string) bool {
+	membership, ok := p.Channels[name]
+	return !ok || membership.Joined
+}
+
+func (c *Channel) color() string {
+	if color, ok := colorNames[c.Color]; ok {
+		return color
+	}
+	return Reset
+}
+
+// record adds the message to the channel's history.
+func (c *Channel) record(text string) {
+	channels.Lock()
+	defer channels.Unlock()
+	c.history = append(c.history, channelMessage{at: time.Now(), text: text})
+	if len(c.history) > channelHistorySize {
+		c.history = c.history[len(c.history)-channelHistorySize:]
+	}
+}
+
+// History returns the channel's last n messages, oldest first, with the time they were sent.
+func (c *Channel) History(n int) []string {
+	channels.RLock()
+	defer channels.RUnlock()
+	if n > len(c.history) || n < 1 {
+		n = len(c.history)
+	}
+	var lines []string
+	for _, message := range c.history[len(c.history)-n:] {
+		lines = append(lines, c.color()+message.at.Format("15:04")+" "+message.text+Reset)
+	}
+	return lines
+}
+
+// channelWrite writes the text to every player online who can use the channel and is on it.
+func channelWrite(channel *Channel, text string, world *World) {
+	written := map[identifier]bool{}
+	for _, s := range Sessions() {
+		id := s.Player()
+		if id == invalidIdentifier || written[id] {
+			continue
+		}
+		written[id] = true
+		player, exists := world.players.GetById(id)
+		if !exists || player.Role() < channel.Role || !player.OnChannel(channel.Name) {
+			continue
+		}
+		player.Write(channel.color() + text + Reset)
+	}
+}
+
+// channelSay sends the message to the channel, from the player.
+func channelSay(name string, message string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("channelSay called with invalid player " + playerId.String())
+		return
+	}
+	channel, ok := getChannel(name, player.Role())
+	if !ok {
+		player.Write(commandRejectMessage)
+		return
+	}
+	if !player.OnChannel(name) {
+		player.Write("You aren't on the " + name + " channel. Type 'join " + name + "' to join it.")
+		return
+	}
+	if player.Channels[name].Muted {
+		player.Write("You have been muted on the " + name + " channel.")
+		return
+	}
+	text := "[" + name + "] " + ToProper(player.Name()) + ": " + message
+	channel.record(text)
+	channelWrite(channel, text, world)
+}
+
+func loadChannels(db *sql.DB) {
+	rows, err := db.Query(`select name, role, color from channels;`)
+	if err != nil {
+		fmt.Print("dberr loadChannels ")
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+	channels.Lock()
+	defer channels.Unlock()
+	for rows.Next() {
+		channel := Channel{}
+		rows.Scan(&channel.Name, &channel.Role, &channel.Color)
+		if _, exists := channels.all[channel.Name]; exists {
+			continue
+		}
+		channels.all[channel.Name] = &channel
+	}
+}
+
+func saveChannel(db *sql.DB, channel *Channel) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`insert or replace into channels (name, role, color) values (?,?,?);`, channel.Name, channel.Role, channel.Color); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// deleteChannel deletes the channel, and players' memberships of it.
+func deleteChannel(db *sql.DB, name string) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`delete from channels where name = ?;`, name); err != nil {
+		dbWriteError(err)
+	}
+	if _, err := tx.Exec(`delete from player_channels where channel = ?;`, name); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// listChannels lists the channels the player can use, and whether they're on them.
+func listChannels(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("channels called with invalid player " + playerId.String())
+		return
+	}
+	s := fmt.Sprintf("%-12s%s", "channel", "status")
+	for _, name := range channelNames(player.Role()) {
+		channel, ok := getChannel(name, player.Role())
+		if !ok {
+			continue
+		}
+		status := "on"
+		if !player.OnChannel(name) {
+			status = "off"
+		}
+		if player.Channels[name].Muted {
+			status += ", muted"
+		}
+		s += "\r\n" + channel.color() + fmt.Sprintf("%-12s", name) + Reset + status
+	}
+	player.Write(s)
+}
+
+// joinChannel puts the player on the channel, and replays its history.
+func joinChannel(args []string, playerId identifier, world *World) {
+	name := strings.ToLower(args[0])
+	world.players.ChangeById(playerId, func(p *Player) {
+		channel, ok := getChannel(name, p.Role())
+		if !ok {
+			p.Write("There is no channel '" + name + "'.")
+			return
+		}
+		if p.OnChannel(name) {
+			p.Write("You're already on the " + name + " channel.")
+			return
+		}
+		membership := p.Channels[name]
+		membership.Joined = true
+		if p.Channels == nil {
+			p.Channels = make(map[string]ChannelMembership)
+		}
+		p.Channels[name] = membership
+		s := "You join the " + name + " channel."
+		if history := channel.History(0); len(history) > 0 {
+			s += "\r\n" + strings.Join(history, "\r\n")
+		}
+		p.Write(s)
+	})
+}
+
+// replayChannels shows a new player the history of the channels they're on, which they didn't join.
+func replayChannels(playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("replayChannels called with invalid player " + playerId.String())
+		return
+	}
+	for _, name := range channelNames(player.Role()) {
+		channel, ok := getChannel(name, player.Role())
+		if !ok || !player.OnChannel(name) {
+			continue
+		}
+		if history := channel.History(0); len(history) > 0 {
+			player.Write("Recently on the " + name + " channel:\r\n" + strings.Join(history, "\r\n"))
+		}
+	}
+}
+
+func leaveChannel(args []string, playerId identifier, world *World) {
+	name := strings.ToLower(args[0])
+	world.players.ChangeById(playerId, func(p *Player) {
+		if _, ok := getChannel(name, p.Role()); !ok {
+			p.Write("There is no channel '" + name + "'.")
+			return
+		}
+		if !p.OnChannel(name) {
+			p.Write("You aren't on the " + name + " channel.")
+			return
+		}
+		membership := p.Channels[name]
+		membership.Joined = false
+		if p.Channels == nil {
+			p.Channels = make(map[string]ChannelMembership)
+		}
+		p.Channels[name] = membership
+		p.Write("You leave the " + name + " channel.")
+	})
+}
+
+// channelHistory replays the channel's last messages, e.g. 'history ooc 5'. With no count, it replays all it remembers.
+func channelHistory(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("history called with invalid player " + playerId.String())
+		return
+	}
+	name := strings.ToLower(args[0])
+	channel, ok := getChannel(name, player.Role())
+	if !ok {
+		player.Write("There is no channel '" + name + "'.")
+		return
+	}
+	n := 0
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			player.Write("Usage: history channel [count]")
+			return
+		}
+	}
+	history := channel.History(n)
+	if len(history) == 0 {
+		player.Write("Nothing has been said on the " + name + " channel.")
+		return
+	}
+	player.Write(strings.Join(history, "\r\n"))
+}
+
+// channelAdmin creates and removes channels, and mutes players on them.
+func channelAdmin(args []string, playerId identifier, world *World) {
+	const usage = "Usage: channel create name [role] [color]/remove name/mute name player/unmute name player"
+	if len(args) < 2 {
+		tryPlayerWrite(playerId, world.players, usage, "channel called with invalid player")
+		return
+	}
+	name := strings.ToLower(args[1])
+	switch strings.ToLower(args[0]) {
+	case "create":
+		createChannel(name, args[2:], playerId, world)
+	case "remove":
+		removeChannel(name, playerId, world)
+	case "mute", "unmute":
+		if len(args) < 3 {
+			tryPlayerWrite(playerId, world.players, usage, "channel called with invalid player")
+			return
+		}
+		muteOnChannel(name, strings.ToLower(args[2]), strings.ToLower(args[0]) == "mute", playerId, world)
+	default:
+		tryPlayerWrite(playerId, world.players, usage, "channel called with invalid player")
+	}
+}
+
+func createChannel(name string, args []string, playerId identifier, world *World) {
+	if valid, err := regexp.MatchString(validChannelRegex, name); err != nil || !valid {
+		tryPlayerWrite(playerId, world.players, "Channel names can only contain letters.", "channel called with invalid player")
+		return
+	}
+	if _, exists := getSocial(name); exists {
+		tryPlayerWrite(playerId, world.players, "There is already a social called '"+name+"'.", "channel called with invalid player")
+		return
+	}
+	if isCommandPrefix(name) {
+		tryPlayerWrite(playerId, world.players, "'"+name+"' is the start of a command, so it can't be a channel.", "channel called with invalid player")
+		return
+	}
+	channel := Channel{Name: name, Role: rolePlayer, Color: "grey"}
+	if len(args) > 0 {
+		role, ok := StringToRole(args[0])
+		if !ok {
+			tryPlayerWrite(playerId, world.players, "There is no role '"+args[0]+"'.", "channel called with invalid player")
+			return
+		}
+		channel.Role = role
+	}
+	if len(args) > 1 {
+		color := strings.ToLower(args[1])
+		if _, ok := colorNames[color]; !ok {
+			names := make([]string, 0, len(colorNames))
+			for name := range colorNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			tryPlayerWrite(playerId, world.players, "Channel colors can be: "+strings.Join(names, ", "), "channel called with invalid player")
+			return
+		}
+		channel.Color = color
+	}
+	channels.Lock()
+	if _, exists := channels.all[name]; exists {
+		channels.Unlock()
+		tryPlayerWrite(playerId, world.players, "There is already a channel '"+name+"'.", "channel called with invalid player")
+		return
+	}
+	channels.all[name] = &channel
+	channels.Unlock()
+	saveChannel(world.db, &channel)
+	tryPlayerWrite(playerId, world.players, "You create the "+name+" channel.", "channel called with invalid player")
+}
+
+func removeChannel(name string, playerId identifier, world *World) {
+	channels.Lock()
+	channel, exists := channels.all[name]
+	if !exists || channel.Builtin {
+		channels.Unlock()
+		if exists {
+			tryPlayerWrite(playerId, world.players, "The "+name+" channel is built in, and can't be removed.", "channel called with invalid player")
+		} else {
+			tryPlayerWrite(playerId, world.players, "There is no channel '"+name+"'.", "channel called with invalid player")
+		}
+		return
+	}
+	delete(channels.all, name)
+	channels.Unlock()
+	deleteChannel(world.db, name)
+	tryPlayerWrite(playerId, world.players, "You remove the "+name+" channel.", "channel called with invalid player")
+}
+
+// muteOnChannel mutes or unmutes a player on a channel. Admins can only mute players with lower roles than their own.
+func muteOnChannel(name string, playerName string, mute bool, playerId identifier, world *World) {
+	admin, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("channel called with invalid player " + playerId.String())
+		return
+	}
+	if _, ok := getChannel(name, roleOwner); !ok {
+		admin.Write("There is no channel '" + name + "'.")
+		return
+	}
+	target, exists := world.players.GetByName(playerName)
+	if !exists {
+		admin.Write("There is no player '" + playerName + "' online.")
+		return
+	}
+	if target.Role() >= admin.Role() {
+		admin.Write("You can't mute " + ToProper(target.Name()) + ".")
+		return
+	}
+	world.players.ChangeById(target.Id(), func(p *Player) {
+		if p.Channels == nil {
+			p.Channels = make(map[string]ChannelMembership)
+		}
+		membership, ok := p.Channels[name]
+		if !ok {
+			membership.Joined = true
+		}
+		membership.Muted = mute
+		p.Channels[name] = membership
+		if mute {
+			p.Write("You have been muted on the " + name + " channel.")
+			admin.Write("You mute " + ToProper(p.Name()) + " on the " + name + " channel.")
+		} else {
+			p.Write("You have been unmuted on the " + name + " channel.")
+			admin.Write("You unmute " + ToProper(p.Name()) + " on the " + name + " channel.")
+		}
+	})
+}
@@ -1,7 +1,8 @@
 /*
 commands.go handles the routing of commands.
 
-When a player types a command, the cooresponding function is called from the commands map
+When a player types a command, the cooresponding function is called from the commands table.
+See parser.go for how lines are parsed into commands.
 
 Note Commands are the primary place the "chain locking" pattern is used.
 If you get more than 1 setter at once, you MUST use this pattern to prevent deadlock and starvation.
@@ -12,17 +13,16 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const commandRejectMessage = "I don't understand."
 
-type CommandFunc func([]string, identifier, *World)
-
-var commands = map[string]CommandFunc{}
-
 func ToProper(player string) string {
 	if len(player) == 0 {
 		return player
@@ -78,8 +78,8 @@ func sayMsg(message string, playerId identifier, world *World) {
 	message = ToSentence(message)
 	RoomMessage := Pink + ToProper(player.Name()) + " says, \"" + message + "\"" + Reset // @todo make this locale aware, << >> vs " " vs ' '
 	selfMessage := Pink + "You say, \"" + message + "\"" + Reset
-	go r.Write(RoomMessage, *world.players, player.Name())
-	go player.Write(selfMessage)
+	r.Write(RoomMessage, *world.players, player.Name())
+	player.Write(selfMessage)
 }
 
 func tell(args []string, playerId identifier, world *World) {
@@ -103,21 +103,21 @@ func tellMsg(message string, playerId identifier, telleePlayer string, world *Wo
 	}
 
 	if player.Name() == telleePlayer {
-		go player.Write("Your own voice reverberates in your head.")
+		player.Write("Your own voice reverberates in your head.")
 		return
 	}
 
 	tellee, exists := world.players.GetByName(telleePlayer)
 	if !exists {
-		go player.Write("Your own voice reverberates in your head.")
+		player.Write("Your own voice reverberates in your head.")
 		return
 	}
 
 	message = ToSentence(message)
 	telleeMessage := Cyan + ToProper(player.Name()) + " tells you, \"" + message + "\"" + Reset // @todo make this locale aware, << >> vs " " vs ' '
 	tellerMessage := Cyan + "You tell " + ToProper(telleePlayer) + ", \"" + message + "\"" + Reset
-	go tellee.Write(telleeMessage)
-	go player.Write(tellerMessage)
+	tellee.Write(telleeMessage)
+	player.Write(tellerMessage)
 }
 
 func walkSouth(args []string, playerId identifier, world *World) {
@@ -162,6 +162,10 @@ func walk(d Direction, playerId identifier, world *World) {
 }
 
 func look(args []string, playerId identifier, world *World) {
+	if len(args) > 0 {
+		lookAt(strings.Join(args, " "), playerId, world)
+		return
+	}
 	player, exists := world.players.GetById(playerId)
 	if !exists {
 		fmt.Println("look called with invalid player id '" + playerId.String() + "'")
@@ -176,6 +180,150 @@ func look(args []string, playerId identifier, world *World) {
 	}
 }
 
+// lookAt shows the player the things they're carrying or can see which the target means.
+// Items and NPCs are searched first, then the players in the room, then the room's extra descriptions.
+func lookAt(targetString string, playerId identifier, world *World) {
+	target, ok := ParseTarget(targetString)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to look at?", "look called with invalid player")
+		return
+	}
+	err := doWithTarget(world, playerId, tsInventoryAndRoom, target, func(player *Player, room *Room, targets []identifier, got Got) {
+		for _, id := range targets {
+			if item, ok := got.items[id]; ok {
+				if item.IsContainer() {
+					player.Write(describeThing(item.Description(), item.Brief()) + "\r\n" + containerContents(item, world))
+				} else {
+					player.Write(describeThing(item.Description(), item.Brief()))
+				}
+			} else if npc, ok := got.npcs[id]; ok {
+				player.Write(describeThing(npc.Description, npc.Brief))
+			}
+		}
+		if len(targets) > 0 || target.All || target.Id != invalidIdentifier {
+			if len(targets) == 0 {
+				player.Write(tsInventoryAndRoom.NotFound())
+			}
+			return
+		}
+		if other, ok := findPlayerInRoom(target, player, room, world); ok {
+			player.Write(describePlayer(other, world))
+			return
+		}
+		if description, ok := findRoomExtra(target, room); ok {
+			player.Write(description)
+			return
+		}
+		player.Write(tsInventoryAndRoom.NotFound())
+	})
+	if err != nil {
+		fmt.Printf("look error: %v\n", err)
+	}
+}
+
+// describeThing returns the long description of an item or NPC, or its brief if it has none.
+func describeThing(description string, brief string) string {
+	if description != "" {
+		return description
+	}
+	return ToSentence(brief)
+}
+
+// findPlayerInRoom returns the player in the room which the target means. The looking player is already held,
+// and is returned as-is if they're the one meant.
+func findPlayerInRoom(target Target, player *Player, room *Room, world *World) (*Player, bool) {
+	ids := make([]identifier, 0, len(room.Players))
+	for id := range room.Players {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	n := 0
+	for _, id := range ids {
+		other := player
+		if id != player.Id() {
+			var exists bool
+			if other, exists = world.players.GetById(id); !exists {
+				continue
+			}
+		}
+		if !target.Matches([]string{strings.ToLower(other.Name())}) {
+			continue
+		}
+		n++
+		if n == target.Ordinal {
+			return other, true
+		}
+	}
+	return nil, false
+}
+
+// describePlayer returns what a player looking at the other player sees: their description and what they're carrying.
+func describePlayer(other *Player, world *World) string {
+	s := other.Description
+	if s == "" {
+		s = "You see nothing special about " + other.Name() + "."
+	}
+	var carrying []string
+	for _, id := range sortedIds(other.Items) {
+		if other.Items[id] != piItem {
+			continue
+		}
+		if item, exists := world.items.GetById(id); exists {
+			carrying = append(carrying, item.Brief())
+		}
+	}
+	if len(carrying) == 0 {
+		return s + "\r\n" + other.Name() + " is carrying nothing."
+	}
+	return s + "\r\n" + other.Name() + " is carrying:\r\n" + strings.Join(carrying, "\r\n")
+}
+
+// findRoomExtra returns the room's extra description whose keywords the target matches.
+func findRoomExtra(target Target, room *Room) (string, bool) {
+	keywords := make([]string, 0, len(room.Extras))
+	for k := range room.Extras {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+	n := 0
+	for _, k := range keywords {
+		if !target.Matches(strings.Fields(k)) {
+			continue
+		}
+		n++
+		if n == target.Ordinal {
+			return room.Extras[k], true
+		}
+	}
+	return "", false
+}
+
+func examine(args []string, playerId identifier, world *World) {
+	lookAt(strings.Join(args, " "), playerId, world)
+}
+
+// describeSelf sets what other players see when they look at the player. With no description, it shows the current one.
+func describeSelf(args []string, playerId identifier, world *World) {
+	description := strings.Join(args, " ")
+	world.players.ChangeById(playerId, func(p *Player) {
+		if description == "" {
+			if p.Description == "" {
+				p.Write("You have no description.")
+			} else {
+				p.Write("Your description is:\r\n" + p.Description)
+			}
+			return
+		}
+		if description == "clear" {
+			p.Description = ""
+			p.Write("Your description has been cleared.")
+			return
+		}
+		p.Description = description
+		p.Write("Your description has been set.")
+	})
+}
+
 func quicklook(args []string, playerId identifier, world *World) {
 	player, exists := world.players.GetById(playerId)
 	if !exists {
@@ -224,6 +372,7 @@ func makeRoom(direction Direction, name string, playerId identifier, world *Worl
 			Exits:       make(map[Direction]identifier),
 			Players:     make(map[identifier]bool),
 			Items:       make(map[identifier]PlayerItemType),
+			Extras:      make(map[string]string),
 		}
 		newRoom.Exits[direction.reverse()] = roomSet.it.Id()
 		newRoomId := ThingManager(*world.rooms).Add(&newRoom)
@@ -298,7 +447,7 @@ func connectRoom(args []string, playerId identifier, world *World) {
 
 func describeRoom(args []string, playerId identifier, world *World) {
 	if len(args) < 1 {
-		tryPlayerWrite(playerId, world.players, commandRejectMessage, "describeRoom called with invalid player")
+		tryPlayerWrite(playerId, world.players, "How do you want to describe the room?", "describeRoom called with invalid player")
 		return // false
 	}
 
@@ -362,6 +511,7 @@ func createItem(args []string, playerId identifier, world *World) {
 		Location:     playerId,
 		LocationType: ilPlayer,
 		Items:        make(map[identifier]bool),
+		Key:          invalidIdentifier,
 	}
 	id := ThingManager(*world.items).Add(&item)
 	world.players.ChangeById(playerId, func(player *Player) {
@@ -384,6 +534,7 @@ func createNpc(args []string, playerId identifier, world *World) {
 		Dna:          "",
 		Sleeping:     false,
 		Items:        make(map[identifier]bool),
+		Hooks:        make(map[string]string),
 	}
 	id := ThingManager(*world.npcs).Add(&npc)
 	world.players.ChangeById(playerId, func(player *Player) {
@@ -452,230 +603,193 @@ func describeItem(args []string, playerId identifier, world *World) {
 	})
 }
 
-func get(args []string, playerId identifier, world *World) {
-	const notHereMsg = "That is not here."
-	const cantGetMsg = "You can't pick that up."
+// keywordItem sets the words players can refer to an item by, besides its name, e.g. 'keyworditem 42 rusty iron'.
+func keywordItem(args []string, playerId identifier, world *World) {
 	if len(args) < 1 {
-		tryPlayerWrite(playerId, world.players, "What do you want to get?", "get called with invalid params")
-		return // false
+		tryPlayerWrite(playerId, world.players, "What do you want to set the keywords of?", "keywordItem called with invalid params")
+		return
 	}
+	itemInt, err := strconv.Atoi(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid identifier to set the keywords of.", "keywordItem called with invalid id")
+		return
+	}
+	keywords := strings.ToLower(strings.Join(args[1:], " "))
+	if !world.items.ChangeById(identifier(itemInt), func(i *Item) {
+		i.keywords = keywords
+		tryPlayerWrite(playerId, world.players, "The "+i.Name()+" is now known as: "+strings.Join(i.Keywords(), " "), "keywordItem succeeded but player disappeared")
+	}) {
+		tryPlayerWrite(playerId, world.players, "There is no item with that identifier.", "keywordItem called with invalid id")
+	}
+}
 
-	chainTime := <-NextChainTime
-	playerAccessor := ThingManager(*world.players).GetThingAccessor(playerId)
-	for {
-		sets := make([]SetterMsg, 0, 2)
-		playerSet, ok, resetChain := playerAccessor.TryGet(chainTime)
-		if !ok {
-			fmt.Println("get error: player chan closed " + playerId.String())
-			return // false
-		} else if resetChain {
-			//			fmt.Println("chain prempted: looping " + playerId.String())
-			continue
-		}
-		sets = append(sets, playerSet)
-		player := playerSet.it.(*Player)
-		roomId := player.Room
-		roomAccessor := ThingManager(*world.rooms).GetThingAccessor(roomId)
-		roomSet, ok, resetChain := roomAccessor.TryGet(chainTime)
-		if !ok {
-			fmt.Println("get error: room chan closed " + player.Id().String() + " room " + roomId.String())
-			ReleaseThings(sets)
-			return // false
-		} else if resetChain {
-			//			fmt.Println("chain prempted: looping " + playerId.String())
-			ReleaseThings(sets)
-			continue
-		}
-		sets = append(sets, roomSet)
+// detailItem sets the long description players see when they look at an item. With no description, it's removed.
+func detailItem(args []string, playerId identifier, world *World) {
+	itemInt, err := strconv.Atoi(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid identifier to detail.", "detailItem called with invalid id")
+		return
+	}
+	description := strings.Join(args[1:], " ")
+	if !world.items.ChangeById(identifier(itemInt), func(i *Item) {
+		i.description = description
+		tryPlayerWrite(playerId, world.players, "The "+i.Name()+" shimmers for a minute, looking strangely more detailed after.", "detailItem succeeded but player disappeared")
+	}) {
+		tryPlayerWrite(playerId, world.players, "There is no item with that identifier.", "detailItem called with invalid id")
+	}
+}
 
-		itemInt, err := strconv.Atoi(args[0])
-		room := roomSet.it.(*Room)
-		if err == nil {
-			itemId := identifier(itemInt)
-			itemType, ok := room.Items[itemId]
-			if !ok {
-				tryPlayerWrite(playerId, world.players, notHereMsg, "get called with invalid id")
-				ReleaseThings(sets)
-				return // false
-			} else if itemType != piItem {
-				tryPlayerWrite(playerId, world.players, cantGetMsg, "get called with nonitem")
-				ReleaseThings(sets)
-				return // false
+// detailNpc sets the long description players see when they look at an NPC. With no description, it's removed.
+func detailNpc(args []string, playerId identifier, world *World) {
+	npcInt, err := strconv.Atoi(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid identifier to detail.", "detailNpc called with invalid id")
+		return
+	}
+	description := strings.Join(args[1:], " ")
+	if !world.npcs.ChangeById(identifier(npcInt), func(n *Npc) {
+		n.Description = description
+		tryPlayerWrite(playerId, world.players, "The "+n.Name()+" shimmers for a minute, looking strangely more detailed after.", "detailNpc succeeded but player disappeared")
+	}) {
+		tryPlayerWrite(playerId, world.players, "There is no NPC with that identifier.", "detailNpc called with invalid id")
+	}
+}
+
+// roomExtra adds, removes and lists the room's extra descriptions, which players see when they look at their keywords,
+// e.g. 'roomextra fountain basin = The fountain is dry.'. With nothing after the '=', the extra is removed.
+func roomExtra(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("roomextra called with invalid player " + playerId.String())
+		return
+	}
+	line := strings.Join(args, " ")
+	if line == "" {
+		world.rooms.ChangeById(player.Room, func(r *Room) {
+			if len(r.Extras) == 0 {
+				player.Write("This room has no extra descriptions.")
+				return
 			}
-		} else {
-			found := false
-			npcFound := false
-			for itemId, itemType := range room.Items {
-				if npcFound == false && itemType == piNpc {
-					_, npcFound = world.npcs.GetById(itemId)
-					continue
-				}
-				if itemType != piItem {
-					continue
-				} else if it, exists := world.items.GetById(itemId); !exists {
-					fmt.Println("get got nonexistent item in room '" + itemId.String() + "'")
-					continue
-				} else if it.Name() == args[0] {
-					itemInt = int(it.Id())
-					found = true
-					break
-				}
+			keywords := make([]string, 0, len(r.Extras))
+			for k := range r.Extras {
+				keywords = append(keywords, k)
 			}
-			if !found {
-				if npcFound {
-					tryPlayerWrite(playerId, world.players, cantGetMsg, "get player failed to write")
-				} else {
-					tryPlayerWrite(playerId, world.players, notHereMsg, "get player failed to write")
-				}
-				ReleaseThings(sets)
-				return // false
+			sort.Strings(keywords)
+			s := "This room's extra descriptions:"
+			for _, k := range keywords {
+				s += "\r\n" + k + "\t" + r.Extras[k]
+			}
+			player.Write(s)
+		})
+		return
+	}
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		player.Write("Usage: roomextra [keywords = description]")
+		return
+	}
+	keywords := strings.Join(strings.Fields(strings.ToLower(line[:eq])), " ")
+	description := strings.TrimSpace(line[eq+1:])
+	if keywords == "" {
+		player.Write("What keywords should the description have?")
+		return
+	}
+	world.rooms.ChangeById(player.Room, func(r *Room) {
+		if description == "" {
+			if _, ok := r.Extras[keywords]; !ok {
+				player.Write("This room has no extra description for '" + keywords + "'.")
+				return
 			}
+			delete(r.Extras, keywords)
+			player.Write("The " + keywords + " fades away.")
+			return
 		}
-
-		itemAccessor := ThingManager(*world.items).GetThingAccessor(identifier(itemInt))
-		itemSet, ok, resetChain := itemAccessor.TryGet(chainTime)
-		if !ok {
-			tryPlayerWrite(playerId, world.players, notHereMsg, "get error: item chan closed")
-			ReleaseThings(sets)
-			return // false
-		} else if resetChain {
-			ReleaseThings(sets)
-			continue
+		if r.Extras == nil {
+			r.Extras = make(map[string]string)
 		}
-		sets = append(sets, itemSet)
-
-		itemId := identifier(itemInt)
-		item := itemSet.it.(*Item)
-		delete(room.Items, itemId)
-		player.Items[itemId] = piItem
-		item.Location = player.Id()
-		itemSet.it = item
-		player.Write("You pick up " + item.Brief())
-		room.Write(ToProper(player.Name())+" picks up "+item.Brief(), *world.players, player.Name())
-		ReleaseThings(sets)
-		break
-	}
-	return // true
+		r.Extras[keywords] = description
+		player.Write("The " + keywords + " seems a bit more corporeal.")
+	})
 }
 
-func drop(args []string, playerId identifier, world *World) {
-	if len(args) < 1 {
-		tryPlayerWrite(playerId, world.players, "What do you want to drop?", "drop called with invalid params")
-		return // false
+// get picks up items in the room, or gets them from a container. See target.go for what players can type to refer to them.
+func get(args []string, playerId identifier, world *World) {
+	const cantGetMsg = "You can't pick that up."
+	if itemString, containerString, ok := splitContainerArgs(args, "from"); ok {
+		getFrom(itemString, containerString, playerId, world)
+		return
 	}
-
-	chainTime := <-NextChainTime
-	playerAccessor := ThingManager(*world.players).GetThingAccessor(playerId)
-	for {
-		sets := make([]SetterMsg, 0, 3)
-		playerSet, ok, resetChain := playerAccessor.TryGet(chainTime)
-		if !ok {
-			fmt.Println("drop error: player chan closed " + playerId.String())
-			return // false
-		} else if resetChain {
-			//			fmt.Println("drop prempted: looping " + playerId.String())
-			continue
-		}
-		sets = append(sets, playerSet)
-		player := playerSet.it.(*Player)
-		roomAccessor := ThingManager(*world.rooms).GetThingAccessor(player.Room)
-		roomSet, ok, resetChain := roomAccessor.TryGet(chainTime)
-		if !ok {
-			fmt.Println("drop error: room chan closed " + playerId.String())
-			ReleaseThings(sets)
-			return // false
-		} else if resetChain {
-			ReleaseThings(sets)
-			continue
+	target, ok := ParseTarget(strings.Join(args, " "))
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to get?", "get called with invalid params")
+		return
+	}
+	err := doWithTarget(world, playerId, tsRoom, target, func(player *Player, room *Room, targets []identifier, got Got) {
+		if len(targets) == 0 {
+			player.Write(tsRoom.NotFound())
+			return
 		}
-		sets = append(sets, roomSet)
-
-		var dropType PlayerItemType = piItem
-		itemInt, err := strconv.Atoi(args[0])
-		if err == nil {
-			itemId := identifier(itemInt)
-			dropType, ok = player.Items[itemId]
+		picked := 0
+		for _, itemId := range targets {
+			item, ok := got.items[itemId]
 			if !ok {
-				tryPlayerWrite(playerId, world.players, "You aren't holding that.", "drop called with invalid id")
-				ReleaseThings(sets)
-				return // false
-			}
-
-		} else {
-			found := false
-			for itemId, _ := range player.Items {
-				var it Thing
-				item, exists := world.items.GetById(itemId)
-				if !exists {
-					npc, npcExists := world.npcs.GetById(itemId)
-					if npcExists {
-						exists = true
-						dropType = piNpc
-						it = Thing(npc)
-					}
-				} else {
-					it = Thing(item)
+				if !target.All {
+					player.Write(cantGetMsg)
 				}
-				if !exists {
-					fmt.Println("drop got nonexistent item in player '" + itemId.String() + "'")
-					continue
-				} else if it.Name() == args[0] {
-					itemInt = int(it.Id())
-					found = true
-					break
-				}
-			}
-			if !found {
-				tryPlayerWrite(playerId, world.players, "You aren't holding that.", "drop player failed to write")
-				ReleaseThings(sets)
-				return // false
+				continue
 			}
+			delete(room.Items, itemId)
+			player.Items[itemId] = piItem
+			item.Location = player.Id()
+			item.LocationType = ilPlayer
+			player.Write("You pick up " + item.Brief())
+			room.Write(ToProper(player.Name())+" picks up "+item.Brief(), *world.players, player.Name())
+			picked++
 		}
-
-		var itemAccessor ThingAccessor
-		if dropType == piItem {
-			itemAccessor = ThingManager(*world.items).GetThingAccessor(identifier(itemInt))
-		} else {
-			itemAccessor = ThingManager(*world.npcs).GetThingAccessor(identifier(itemInt))
+		if picked == 0 && target.All {
+			player.Write("There is nothing here you can pick up.")
 		}
-		itemSet, ok, resetChain := itemAccessor.TryGet(chainTime)
-		if !ok {
-			tryPlayerWrite(playerId, world.players, "You aren't holding that.", "drop error: item chan closed")
-			ReleaseThings(sets)
-			return // false
-		} else if resetChain {
-			ReleaseThings(sets)
-			continue
+	})
+	if err != nil {
+		fmt.Printf("get error: %v\n", err)
+	}
+}
+
+// drop drops items and NPCs the player is carrying.
+func drop(args []string, playerId identifier, world *World) {
+	target, ok := ParseTarget(strings.Join(args, " "))
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to drop?", "drop called with invalid params")
+		return
+	}
+	err := doWithTarget(world, playerId, tsInventory, target, func(player *Player, room *Room, targets []identifier, got Got) {
+		if len(targets) == 0 {
+			player.Write(tsInventory.NotFound())
+			return
 		}
-		sets = append(sets, itemSet)
-
-		itemId := identifier(itemInt)
-		room := roomSet.it.(*Room)
-		delete(player.Items, itemId)
-		room.Items[itemId] = dropType
-		var itemBrief string
-		if dropType == piItem {
-			item := itemSet.it.(*Item)
-			item.Location = room.Id()
-			item.LocationType = ilRoom
-			itemSet.it = item
-			itemBrief = item.Brief()
-		} else if dropType == piNpc {
-			npc := itemSet.it.(*Npc)
-			npc.Location = room.Id()
-			npc.LocationType = ilRoom
-			itemSet.it = npc
-			itemBrief = npc.Brief
-			npc.Animate(world)
-		} else {
-			panic("Unknown drop type (dev: you need to implement)")
+		for _, itemId := range targets {
+			var itemBrief string
+			if item, ok := got.items[itemId]; ok {
+				item.Location = room.Id()
+				item.LocationType = ilRoom
+				itemBrief = item.Brief()
+			} else if npc, ok := got.npcs[itemId]; ok {
+				npc.Location = room.Id()
+				npc.LocationType = ilRoom
+				itemBrief = npc.Brief
+				npc.Animate(world)
+			} else {
+				continue
+			}
+			room.Items[itemId] = player.Items[itemId]
+			delete(player.Items, itemId)
+			player.Write("You drop " + itemBrief)
+			room.Write(ToProper(player.Name())+" drops "+itemBrief, *world.players, player.Name())
 		}
-		player.Write("You drop " + itemBrief)
-		room.Write(ToProper(player.Name())+" drops "+itemBrief, *world.players, player.Name())
-		ReleaseThings(sets)
-		break
+	})
+	if err != nil {
+		fmt.Printf("drop error: %v\n", err)
 	}
-	return // true
 }
 
 func items(args []string, playerId identifier, world *World) {
@@ -776,7 +890,7 @@ func inventory(args []string, playerId identifier, world *World) {
 				if !exists {
 					continue
 				}
-				items = append(items, it.Brief())
+				items = append(items, containerBrief(it, world, 0))
 			case piNpc:
 				it, exists := world.npcs.GetById(itemId)
 				if !exists {
@@ -819,54 +933,299 @@ func inventory(args []string, playerId identifier, world *World) {
 	})
 }
 
+func wrap(args []string, playerId identifier, world *World) {
+	if len(args) < 1 {
+		world.players.ChangeById(playerId, func(player *Player) {
+			switch {
+			case player.Wrap < 0:
+				player.Write("Your output is not wrapped.")
+			case player.Wrap == 0:
+				player.Write("Your output is wrapped to your window, currently " + strconv.Itoa(player.WrapWidth()) + " columns.")
+			default:
+				player.Write("Your output is wrapped at " + strconv.Itoa(player.Wrap) + " columns.")
+			}
+		})
+		return
+	}
+
+	const minWrap = 20
+	var newWrap int
+	switch strings.ToLower(args[0]) {
+	case "off":
+		newWrap = -1
+	case "auto":
+		newWrap = 0
+	default:
+		width, err := strconv.Atoi(args[0])
+		if err != nil || width < minWrap {
+			tryPlayerWrite(playerId, world.players, "Please give a width of at least "+strconv.Itoa(minWrap)+", 'auto' or 'off'.", "wrap called with invalid player")
+			return
+		}
+		newWrap = width
+	}
+	world.players.ChangeById(playerId, func(player *Player) {
+		player.Wrap = newWrap
+		player.Write("Your wrap width has been changed.")
+	})
+}
+
+// quit leaves the game. Unlike losing the connection, the player is removed from the world immediately.
+func quit(args []string, playerId identifier, world *World) {
+	var c net.Conn
+	world.players.ChangeById(playerId, func(player *Player) {
+		player.state = psQuitting
+		player.Write("Goodbye.")
+		c = player.connection
+	})
+	if c != nil {
+		c.Close() // the player's reader sees the connection close, and removes the player
+	}
+}
+
+// shutdown starts a countdown to shut down the server. 'shutdown now' skips the countdown, and 'shutdown cancel' stops it.
+func shutdownCommand(args []string, playerId identifier, world *World) {
+	delay := world.config.ShutdownDelay
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "cancel":
+			if !cancelShutdown() {
+				tryPlayerWrite(playerId, world.players, "The world isn't ending.", "shutdown called with invalid player")
+			}
+			return
+		case "now":
+			delay = 0
+		default:
+			seconds, err := strconv.Atoi(args[0])
+			if err != nil || seconds < 0 {
+				tryPlayerWrite(playerId, world.players, "Please give a number of seconds, 'now' or 'cancel'.", "shutdown called with invalid player")
+				return
+			}
+			delay = time.Duration(seconds) * time.Second
+		}
+	}
+	if startShutdown(world, delay) {
+		return
+	}
+	if delay == 0 {
+		hurryShutdown()
+		return
+	}
+	tryPlayerWrite(playerId, world.players, "The world is already ending.", "shutdown called with invalid player")
+}
+
+// siteban bans a CIDR range or IP, e.g. 'siteban 10.0.0.0/8 7d spamming'. The duration is optional, and defaults to permanent.
+func siteban(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("siteban error: player not found " + playerId.String())
+		return
+	}
+	if len(args) < 1 {
+		player.Write("Who do you want to ban? siteban range [duration] [reason]")
+		return
+	}
+	network, err := parseSiteBanNetwork(args[0])
+	if err != nil {
+		player.Write("That isn't a valid IP or CIDR range.")
+		return
+	}
+	args = args[1:]
+	ban := SiteBan{Network: network, BannedBy: player.Name()}
+	if len(args) > 0 {
+		if duration, ok := parseBanDuration(args[0]); ok {
+			if duration != 0 {
+				ban.Expires = time.Now().Add(duration)
+			}
+			args = args[1:]
+		}
+	}
+	ban.Reason = strings.Join(args, " ")
+	if ban.Reason == "" {
+		ban.Reason = "no reason given"
+	}
+	addSiteBan(world, ban)
+	if ban.Expires.IsZero() {
+		player.Write(network.String() + " is banned permanently.")
+	} else {
+		player.Write(network.String() + " is banned until " + ban.Expires.Format(time.RFC1123) + ".")
+	}
+}
+
+func siteunban(args []string, playerId identifier, world *World) {
+	if len(args) < 1 {
+		tryPlayerWrite(playerId, world.players, "Who do you want to unban? siteunban range", "siteunban called with invalid player")
+		return
+	}
+	network, err := parseSiteBanNetwork(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "That isn't a valid IP or CIDR range.", "siteunban called with invalid player")
+		return
+	}
+	if !removeSiteBan(world, network) {
+		tryPlayerWrite(playerId, world.players, network.String()+" isn't banned.", "siteunban called with invalid player")
+		return
+	}
+	tryPlayerWrite(playerId, world.players, network.String()+" is no longer banned.", "siteunban called with invalid player")
+}
+
+func sitebans(args []string, playerId identifier, world *World) {
+	bans := SiteBans()
+	if len(bans) == 0 {
+		tryPlayerWrite(playerId, world.players, "No sites are banned.", "sitebans called with invalid player")
+		return
+	}
+	s := ""
+	for _, ban := range bans {
+		expires := "permanent"
+		if !ban.Expires.IsZero() {
+			expires = "until " + ban.Expires.Format(time.RFC1123)
+		}
+		s += ban.Network.String() + "\t" + expires + "\t" + ban.BannedBy + ": " + ban.Reason + "\r\n"
+	}
+	tryPlayerWrite(playerId, world.players, strings.TrimSuffix(s, "\r\n"), "sitebans called with invalid player")
+}
+
+// setRole changes the named player's role, if the player with playerId may. The player needn't be logged in.
+func setRole(playerId identifier, name string, role Role, world *World) {
+	granter, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("setRole error: player not found " + playerId.String())
+		return
+	}
+	name = strings.ToLower(name)
+	if name == granter.Name() {
+		granter.Write("You can't change your own role.")
+		return
+	}
+
+	if target, online := world.players.GetByName(name); online {
+		if !granter.Role().CanGrant(target.Role(), role) {
+			granter.Write("You can't make " + ToProper(name) + " " + role.String() + ".")
+			return
+		}
+		world.players.ChangeById(target.Id(), func(p *Player) {
+			p.role = role
+			p.Write("You are now " + role.String() + ".")
+		})
+		granter.Write(ToProper(name) + " is now " + role.String() + ".")
+		return
+	}
+
+	if world.db == nil {
+		granter.Write("There is no player by that name.")
+		return
+	}
+	var current Role
+	if err := world.db.QueryRow(`select role from players where name = ?;`, name).Scan(&current); err != nil {
+		granter.Write("There is no player by that name.")
+		return
+	}
+	if !granter.Role().CanGrant(current, role) {
+		granter.Write("You can't make " + ToProper(name) + " " + role.String() + ".")
+		return
+	}
+	tx, err := world.db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`update players set role = ? where name = ?;`, role, name); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+	granter.Write(ToProper(name) + " is now " + role.String() + ".")
+}
+
+func grant(args []string, playerId identifier, world *World) {
+	if len(args) < 2 {
+		tryPlayerWrite(playerId, world.players, "Who do you want to grant what? grant player role", "grant called with invalid player")
+		return
+	}
+	role, ok := StringToRole(args[1])
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "Roles are player, builder, admin and owner.", "grant called with invalid player")
+		return
+	}
+	setRole(playerId, args[0], role, world)
+}
+
+func revoke(args []string, playerId identifier, world *World) {
+	if len(args) < 1 {
+		tryPlayerWrite(playerId, world.players, "Whose role do you want to revoke? revoke player", "revoke called with invalid player")
+		return
+	}
+	setRole(playerId, args[0], rolePlayer, world)
+}
+
+// help lists the commands the player may use, or shows how to use one command.
 func help(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("help error: player not found " + playerId.String())
+		return
+	}
+	if len(args) > 0 {
+		command, ok := findCommand(strings.ToLower(args[0]), player.Role())
+		if !ok {
+			player.Write("There is no help on that.")
+			return
+		}
+		s := "Usage: " + command.Syntax()
+		if len(command.Aliases) > 0 {
+			s += "\r\nAliases: " + strings.Join(command.Aliases, " ")
+		}
+		player.Write(s)
+		return
+	}
 	s := "movement\r\n" +
 		"------------------------------\r\n" +
 		"To move in a direction, simply type the cardinal direction you wish to move in, e.g. 'north'. Shortcuts also work, e.g. 'n'.\r\n" +
 		"\r\n" +
 		"\r\n" +
-		"command		brief	syntax\r\n" +
+		"commands\r\n" +
 		"------------------------------\r\n" +
-		"say			say message\r\n" +
-		"tell			tell person message\r\n" +
-		"look		l	look\r\n" +
-		"quicklook	ql	quicklook\r\n" +
-		"makeRoom	mr	makeRoom direction title\r\n" +
-		"connectRoom	cr	connectRoom direction RoomId\r\n" +
-		"describeRoom	dr	describeRoom description\r\n" +
-		"roomid			roomid\r\n" +
-		"createitem	ci	creatitem name description\r\n" +
-		"createnpc	cn	createnpc name\r\n" +
-		"describeitem	di	describeitem itemId description\r\n" +
-		"describenpc	dn	describenpc npcId description\r\n" +
-		"animate	an	animate npcId script\r\n" +
-		"get		g	get itemId/itemName\r\n" +
-		"drop			drop itemId/itemName\r\n" +
-		"items		ii	items\r\n" +
-		"itemshere	ih	itemshere\r\n" +
-		"inventory	i	inventory\r\n" +
-		"\r\n" +
-		"\r\n" +
-		"\r\n" +
-		"animating\r\n" +
-		"------------------------------\r\n" +
-		"NPCs (non-player-characters) can be animated via javascript.\r\n" +
-		"\r\n" +
-		"All gomud commands are newline-delimited, so you must remove all newlines from your script before passing it to animate.\r\n" +
+		"Commands may be shortened, e.g. 'loo' for 'look'. Quote arguments with spaces, e.g. 'get \"long sword\"'.\r\n" +
+		"Type several commands at once by separating them with '" + string(commandSeparator) + "', and '" + repeatLine + "' to repeat your last line.\r\n" +
+		"Type 'help command' for how to use a command, and 'socials' for the socials you can do, e.g. 'smile' or 'bow bob'.\r\n" +
+		"Type 'channels' for the chat channels you're on, and talk on one by typing its name, e.g. 'ooc hello'.\r\n" +
 		"\r\n" +
-		"For efficiency, your script should return as soon as possible. You should call mud_reval() to specify when your script will be called again, immediately before returning.\r\n" +
-		"\r\n" +
-		"A variable named 'self' is available during execution. This is the ID of the current NPC, and necessary for many hook functions.\r\n" +
-		"\r\n" +
-		"The current available 'hook' functions available in Javascript are:\r\n" +
-		"--------------------------------------------------------------------------------\r\n" +
-		"mud_println(text)                      print text to the server's console\r\n" +
-		"mud_getPlayer(name)                    get a struct containing the player's name and ID\r\n" +
-		"mud_moveRandom(self)                   move in a random direction\r\n" +
-		"mud_reval(self, wait)                  execute this NPC's animation script again in *wait* milliseconds\r\n" +
-		"mud_RoomPlayers(self)                  get an array of the names of players in the Room\r\n" +
-		"mud_attackPlayer(self, player, damage) attack the given player for the given integral amount of damage\r\n"
-	tryPlayerWrite(playerId, world.players, s, "help error: player chan closed")
+		fmt.Sprintf("%-15s%-10s%s\r\n", "command", "aliases", "syntax") +
+		"------------------------------\r\n"
+	for i := range commands {
+		command := &commands[i]
+		if player.Role() < command.Role {
+			continue
+		}
+		s += fmt.Sprintf("%-15s%-10s%s\r\n", command.Name, strings.Join(command.Aliases, " "), command.Syntax())
+	}
+	if _, ok := findCommand("animate", player.Role()); ok {
+		s += "\r\n" +
+			"\r\n" +
+			"\r\n" +
+			"animating\r\n" +
+			"------------------------------\r\n" +
+			"NPCs (non-player-characters) can be animated via javascript.\r\n" +
+			"\r\n" +
+			"All gomud commands are newline-delimited, so you must remove all newlines from your script before passing it to animate.\r\n" +
+			"\r\n" +
+			"For efficiency, your script should return as soon as possible. You should call mud_reval() to specify when your script will be called again, immediately before returning.\r\n" +
+			"\r\n" +
+			"A variable named 'self' is available during execution. This is the ID of the current NPC, and necessary for many hook functions.\r\n" +
+			"\r\n" +
+			"The current available 'hook' functions available in Javascript are:\r\n" +
+			"--------------------------------------------------------------------------------\r\n" +
+			"mud_println(text)                      print text to the server's console\r\n" +
+			"mud_getPlayer(name)                    get a struct containing the player's name and ID\r\n" +
+			"mud_moveRandom(self)                   move in a random direction\r\n" +
+			"mud_reval(self, wait)                  execute this NPC's animation script again in *wait* milliseconds\r\n" +
+			"mud_RoomPlayers(self)                  get an array of the names of players in the Room\r\n" +
+			"mud_attackPlayer(self, player, damage) attack the given player for the given integral amount of damage\r\n" +
+			"gomud_say(text)                        say something to the room\r\n" +
+			"\r\n" +
+			"NPCs can also react to events with hooks, set with 'hooknpc npcId event script'. The events are: " + strings.Join(npcHooks, ", ") + ".\r\n" +
+			"A give hook runs for each thing the NPC is given, with 'giver' and 'item' tables, e.g. gomud_say(\"Thank you for the \" .. item.name .. \", \" .. giver.name)\r\n"
+	}
+	player.Write(s)
 }
 
 func makeroom(args []string, playerId identifier, world *World) {
@@ -876,7 +1235,7 @@ func makeroom(args []string, playerId identifier, world *World) {
 			fmt.Println("makeRoom error: getPlayer got nonexistent player " + playerId.String())
 			return
 		}
-		player.Write(commandRejectMessage + "3") ///< @todo give better error
+		player.Write("Usage: makeroom direction title")
 		return
 	}
 	newRoomDirection := stringToDirection(args[0])
@@ -886,7 +1245,7 @@ func makeroom(args []string, playerId identifier, world *World) {
 			fmt.Println("makeRoom error: getPlayer got nonexistent player " + playerId.String())
 			return
 		}
-		player.Write(commandRejectMessage + "4") ///< @todo give better error
+		player.Write("What direction do you want to build in?")
 		return
 	}
 	newRoomName := strings.Join(args[1:], " ")
@@ -896,69 +1255,83 @@ func makeroom(args []string, playerId identifier, world *World) {
 			fmt.Println("makeRoom error: getPlayer got nonexistent player " + playerId.String())
 			return
 		}
-		player.Write(commandRejectMessage + "5") ///< @todo give better error
+		player.Write("What do you want to call the room?")
 		return
 	}
 	makeRoom(newRoomDirection, newRoomName, playerId, world)
 }
 
 func initCommands() {
-	commands = map[string]CommandFunc{
-		// admin
-		"makeroom":     makeroom,
-		"mr":           makeroom,
-		"connectroom":  connectRoom,
-		"cr":           connectRoom,
-		"describeroom": describeRoom,
-		"dr":           describeRoom,
-		"roomid":       RoomId,
-		"createitem":   createItem,
-		"ci":           createItem,
-		"createnpc":    createNpc,
-		"cn":           createNpc,
-		"describeitem": describeItem,
-		"di":           describeItem,
-		"describenpc":  describeNpc,
-		"dn":           describeNpc,
-		"animate":      animate,
-		"an":           animate,
-		"help":         help,
-		"?":            help,
+	commands = []Command{
 		// directions
-		"south":     walkSouth,
-		"s":         walkSouth,
-		"north":     walkNorth,
-		"n":         walkNorth,
-		"east":      walkEast,
-		"e":         walkEast,
-		"west":      walkWest,
-		"w":         walkWest,
-		"northeast": walkNortheast,
-		"ne":        walkNortheast,
-		"northwest": walkNorthwest,
-		"nw":        walkNorthwest,
-		"southeast": walkSoutheast,
-		"se":        walkSoutheast,
-		"southwest": walkSouthwest,
-		"sw":        walkSouthwest,
-		// items
-		"get":       get,
-		"g":         get,
-		"drop":      drop,
-		"items":     items,
-		"ii":        items,
-		"inventory": inventory,
-		"inv":       inventory,
-		"i":         inventory,
-		"itemshere": itemsHere,
-		"ih":        itemsHere,
+		{Name: "north", Aliases: []string{"n"}, Func: walkNorth},
+		{Name: "south", Aliases: []string{"s"}, Func: walkSouth},
+		{Name: "east", Aliases: []string{"e"}, Func: walkEast},
+		{Name: "west", Aliases: []string{"w"}, Func: walkWest},
+		{Name: "northeast", Aliases: []string{"ne"}, Func: walkNortheast},
+		{Name: "northwest", Aliases: []string{"nw"}, Func: walkNorthwest},
+		{Name: "southeast", Aliases: []string{"se"}, Func: walkSoutheast},
+		{Name: "southwest", Aliases: []string{"sw"}, Func: walkSouthwest},
 		// basic commands
-		"look":      look,
-		"l":         look,
-		"quicklook": quicklook,
-		"ql":        quicklook,
-		"say":       say,
-		"'":         say,
-		"tell":      tell,
+		{Name: "look", Aliases: []string{"l"}, Usage: "[[n.]target]", Func: look},
+		{Name: "examine", Aliases: []string{"x"}, MinArgs: 1, Usage: "[n.]target", Func: examine},
+		{Name: "say", Aliases: []string{"'"}, MinArgs: 1, Usage: "message", Raw: true, Func: say},
+		{Name: "tell", MinArgs: 2, Usage: "person message", Raw: true, Func: tell},
+		{Name: "emote", Aliases: []string{":"}, MinArgs: 1, Usage: "action", Raw: true, Func: emote},
+		{Name: "quicklook", Aliases: []string{"ql"}, Func: quicklook},
+		{Name: "wrap", Usage: "[width/auto/off]", Func: wrap},
+		{Name: "help", Aliases: []string{"?"}, Usage: "[command]", Func: help},
+		{Name: "alias", Usage: "[add name expansion/remove name/list]", Raw: true, Protected: true, Func: alias},
+		{Name: "password", Exact: true, Protected: true, Func: password},
+		{Name: "quit", Exact: true, Protected: true, Func: quit},
+		// items
+		{Name: "get", Aliases: []string{"g"}, MinArgs: 1, Usage: "[n.]item/all[.item] [from [n.]container]", Func: get},
+		{Name: "drop", MinArgs: 1, Usage: "[n.]item/all[.item]", Func: drop},
+		{Name: "give", MinArgs: 2, Usage: "[n.]item/all[.item] [to] [n.]person", Func: give},
+		{Name: "put", MinArgs: 3, Usage: "[n.]item/all[.item] in [n.]container", Func: put},
+		{Name: "open", MinArgs: 1, Usage: "[n.]container", Func: openContainer},
+		{Name: "close", MinArgs: 1, Usage: "[n.]container", Func: closeContainer},
+		{Name: "lock", MinArgs: 1, Usage: "[n.]container", Func: lockContainer},
+		{Name: "unlock", MinArgs: 1, Usage: "[n.]container", Func: unlockContainer},
+		{Name: "inventory", Aliases: []string{"inv", "i"}, Func: inventory},
+		{Name: "items", Aliases: []string{"ii"}, Func: items},
+		{Name: "itemshere", Aliases: []string{"ih"}, Func: itemsHere},
+		// after drop, so 'd sword' drops the sword rather than becoming the player's description
+		{Name: "description", Usage: "[text/clear]", Raw: true, Func: describeSelf},
+		// socials and channels, after the commands whose prefixes they'd otherwise take, such as 'p' for put and 'h' for help
+		{Name: "pose", Usage: "[action]", Raw: true, Func: pose},
+		{Name: "socials", Func: listSocials},
+		{Name: "channels", Func: listChannels},
+		{Name: "join", MinArgs: 1, Usage: "channel", Func: joinChannel},
+		{Name: "leave", MinArgs: 1, Usage: "channel", Func: leaveChannel},
+		{Name: "history", MinArgs: 1, Usage: "channel [count]", Func: channelHistory},
+		// building
+		{Name: "makeroom", Aliases: []string{"mr"}, MinArgs: 2, Usage: "direction title", Role: roleBuilder, Raw: true, Func: makeroom},
+		{Name: "connectroom", Aliases: []string{"cr"}, MinArgs: 2, Usage: "direction roomId", Role: roleBuilder, Func: connectRoom},
+		{Name: "describeroom", Aliases: []string{"dr"}, MinArgs: 1, Usage: "description", Role: roleBuilder, Raw: true, Func: describeRoom},
+		{Name: "roomid", Role: roleBuilder, Func: RoomId},
+		{Name: "createitem", Aliases: []string{"ci"}, MinArgs: 2, Usage: "name description", Role: roleBuilder, Raw: true, Func: createItem},
+		{Name: "createnpc", Aliases: []string{"cn"}, MinArgs: 1, Usage: "name", Role: roleBuilder, Func: createNpc},
+		{Name: "describeitem", Aliases: []string{"di"}, MinArgs: 2, Usage: "itemId description", Role: roleBuilder, Raw: true, Func: describeItem},
+		{Name: "keyworditem", Aliases: []string{"ki"}, MinArgs: 1, Usage: "itemId [keywords]", Role: roleBuilder, Func: keywordItem},
+		{Name: "containeritem", Aliases: []string{"coi"}, MinArgs: 2, Usage: "itemId capacity [keyId]", Role: roleBuilder, Func: containerItem},
+		{Name: "describenpc", Aliases: []string{"dn"}, MinArgs: 2, Usage: "npcId description", Role: roleBuilder, Raw: true, Func: describeNpc},
+		{Name: "detailitem", Aliases: []string{"dti"}, MinArgs: 1, Usage: "itemId [description]", Role: roleBuilder, Raw: true, Func: detailItem},
+		{Name: "detailnpc", Aliases: []string{"dtn"}, MinArgs: 1, Usage: "npcId [description]", Role: roleBuilder, Raw: true, Func: detailNpc},
+		{Name: "roomextra", Aliases: []string{"rx"}, Usage: "[keywords = [description]]", Role: roleBuilder, Raw: true, Func: roomExtra},
+		{Name: "editsocial", MinArgs: 1, Usage: "name [message [text]]", Role: roleBuilder, Raw: true, Func: editSocial},
+		{Name: "removesocial", MinArgs: 1, Usage: "name", Role: roleBuilder, Func: removeSocial},
+		{Name: "hooknpc", Aliases: []string{"hn"}, MinArgs: 2, Usage: "npcId event [script]", Role: roleBuilder, Raw: true, Func: hookNpc},
+		{Name: "animate", Aliases: []string{"an"}, MinArgs: 2, Usage: "npcId script", Role: roleBuilder, Raw: true, Func: animate},
+		// admin
+		{Name: "copyover", Role: roleAdmin, Exact: true, Protected: true, Func: copyover},
+		{Name: "shutdown", Usage: "[seconds/now/cancel]", Role: roleAdmin, Exact: true, Protected: true, Func: shutdownCommand},
+		{Name: "siteban", MinArgs: 1, Usage: "range [duration] [reason]", Role: roleAdmin, Raw: true, Func: siteban},
+		{Name: "siteunban", MinArgs: 1, Usage: "range", Role: roleAdmin, Func: siteunban},
+		{Name: "sitebans", Role: roleAdmin, Func: sitebans},
+		{Name: "channel", MinArgs: 2, Usage: "create name [role] [color]/remove name/mute name player/unmute name player", Role: roleAdmin, Func: channelAdmin},
+		{Name: "grant", MinArgs: 2, Usage: "player role", Role: roleAdmin, Func: grant},
+		{Name: "revoke", MinArgs: 1, Usage: "player", Role: roleAdmin, Func: revoke},
+		{Name: "resetpassword", MinArgs: 1, Usage: "account|character", Role: roleAdmin, Exact: true, Protected: true, Func: resetPassword},
 	}
 }
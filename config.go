@@ -0,0 +1,140 @@
+/*
+config.go parses the server configuration from the command line.
+
+The first argument which isn't a flag is the telnet port, as it always has been, e.g.
+
+	gomud 4000
+	gomud -webport 8080 4000
+	gomud -tlsport 4443 -tlscert cert.pem -tlskey key.pem 4000
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Port       int
+	WebPort    int      // 0 disables the web client
+	WebOrigins []string // origins, besides the web client's own, which may open websockets
+	TLSPort    int      // 0 disables telnet over TLS
+	TLSCert    string
+	TLSKey     string
+
+	OutputQueue int // messages queued for each client before the overflow policy applies
+	Overflow    OverflowPolicy
+
+	LinkDeadTimeout time.Duration // how long link-dead players stay in the world; 0 keeps them until the server stops
+	ShutdownDelay   time.Duration // the countdown players get before the server shuts down
+
+	LoginTimeout  time.Duration // how long a client may take to log in; 0 for no limit
+	IdleTimeout   time.Duration // how long a player may be idle before they're disconnected; 0 for no limit
+	MaxLineLength int           // the longest line a client may send
+	CommandRate   float64       // commands per second a player may send; 0 for no limit
+	CommandBurst  int           // commands a player may send at once, before the rate applies
+
+	MaxConnections      int // 0 for no limit
+	MaxConnectionsPerIP int // 0 for no limit
+
+	Owner string // this existing player is made the owner when they log in, for databases with players from before roles
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func parseConfig() Config {
+	config := Config{}
+	flag.IntVar(&config.WebPort, "webport", 0, "port for browser clients (websocket and web page), 0 to disable")
+	webOrigins := flag.String("weborigins", "", "comma-separated origins, besides the web client's own, which may open websockets, e.g. https://example.com")
+	flag.IntVar(&config.TLSPort, "tlsport", 0, "port for telnet over TLS, 0 to disable")
+	flag.StringVar(&config.TLSCert, "tlscert", "", "TLS certificate file; a self-signed certificate is generated if it and -tlskey are empty or missing")
+	flag.StringVar(&config.TLSKey, "tlskey", "", "TLS key file; a self-signed key is generated if it and -tlscert are empty or missing")
+	flag.IntVar(&config.OutputQueue, "outqueue", defaultOutputQueue, "messages queued for each client before the overflow policy applies")
+	overflow := flag.String("overflow", overflowDropOldest.String(), "what to do when a client's output queue is full: drop (the oldest message) or disconnect")
+	flag.DurationVar(&config.LinkDeadTimeout, "linkdead", defaultLinkDeadTimeout, "how long link-dead players stay in the world, e.g. 10m; 0 keeps them until the server stops")
+	flag.DurationVar(&config.ShutdownDelay, "shutdowndelay", defaultShutdownDelay, "the countdown players get before the server shuts down on SIGINT or SIGTERM")
+	flag.DurationVar(&config.LoginTimeout, "logintimeout", defaultLoginTimeout, "how long a client may take to log in; 0 for no limit")
+	flag.DurationVar(&config.IdleTimeout, "idletimeout", defaultIdleTimeout, "how long a player may be idle before they're disconnected; 0 for no limit")
+	flag.IntVar(&config.MaxLineLength, "maxline", defaultMaxLineLength, "the longest line a client may send, in bytes")
+	flag.Float64Var(&config.CommandRate, "cmdrate", defaultCommandRate, "commands per second a player may send; 0 for no limit")
+	flag.IntVar(&config.CommandBurst, "cmdburst", defaultCommandBurst, "commands a player may send at once, before the rate applies")
+	flag.IntVar(&config.MaxConnections, "maxconns", defaultMaxConnections, "the most clients which may be connected at once; 0 for no limit")
+	flag.IntVar(&config.MaxConnectionsPerIP, "maxperip", defaultMaxConnectionsPerIP, "the most clients which may be connected at once from one IP; 0 for no limit")
+	flag.StringVar(&config.Owner, "owner", "", "make this existing player the owner when they log in")
+	flag.Parse()
+	config.Owner = strings.ToLower(config.Owner)
+
+	config.Port = defaultPort
+	if flag.NArg() > 0 {
+		argPort, err := strconv.Atoi(flag.Arg(0))
+		if err != nil || !validPort(argPort) {
+			fmt.Println("invalid port '" + flag.Arg(0) + "', using " + strconv.Itoa(defaultPort))
+		} else {
+			config.Port = argPort
+		}
+	}
+	if config.WebPort != 0 && !validPort(config.WebPort) {
+		fmt.Println("invalid web port '" + strconv.Itoa(config.WebPort) + "', disabling the web client")
+		config.WebPort = 0
+	}
+	for _, origin := range strings.Split(*webOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.WebOrigins = append(config.WebOrigins, strings.ToLower(origin))
+		}
+	}
+	if policy, ok := StringToOverflowPolicy(*overflow); !ok {
+		fmt.Println("invalid overflow policy '" + *overflow + "', using " + config.Overflow.String())
+	} else {
+		config.Overflow = policy
+	}
+	if config.LinkDeadTimeout < 0 {
+		fmt.Println("invalid link-dead timeout '" + config.LinkDeadTimeout.String() + "', using " + defaultLinkDeadTimeout.String())
+		config.LinkDeadTimeout = defaultLinkDeadTimeout
+	}
+	if config.ShutdownDelay < 0 {
+		fmt.Println("invalid shutdown delay '" + config.ShutdownDelay.String() + "', using " + defaultShutdownDelay.String())
+		config.ShutdownDelay = defaultShutdownDelay
+	}
+	if config.LoginTimeout < 0 {
+		fmt.Println("invalid login timeout '" + config.LoginTimeout.String() + "', using " + defaultLoginTimeout.String())
+		config.LoginTimeout = defaultLoginTimeout
+	}
+	if config.IdleTimeout < 0 {
+		fmt.Println("invalid idle timeout '" + config.IdleTimeout.String() + "', using " + defaultIdleTimeout.String())
+		config.IdleTimeout = defaultIdleTimeout
+	}
+	if config.MaxLineLength < 1 {
+		fmt.Println("invalid max line length '" + strconv.Itoa(config.MaxLineLength) + "', using " + strconv.Itoa(defaultMaxLineLength))
+		config.MaxLineLength = defaultMaxLineLength
+	}
+	if config.CommandRate < 0 {
+		fmt.Println("invalid command rate '" + strconv.FormatFloat(config.CommandRate, 'f', -1, 64) + "', disabling the command rate limit")
+		config.CommandRate = 0
+	}
+	if config.CommandBurst < 1 {
+		fmt.Println("invalid command burst '" + strconv.Itoa(config.CommandBurst) + "', using " + strconv.Itoa(defaultCommandBurst))
+		config.CommandBurst = defaultCommandBurst
+	}
+	if config.MaxConnections < 0 {
+		fmt.Println("invalid max connections '" + strconv.Itoa(config.MaxConnections) + "', using " + strconv.Itoa(defaultMaxConnections))
+		config.MaxConnections = defaultMaxConnections
+	}
+	if config.MaxConnectionsPerIP < 0 {
+		fmt.Println("invalid max connections per IP '" + strconv.Itoa(config.MaxConnectionsPerIP) + "', using " + strconv.Itoa(defaultMaxConnectionsPerIP))
+		config.MaxConnectionsPerIP = defaultMaxConnectionsPerIP
+	}
+	if config.OutputQueue < 1 {
+		fmt.Println("invalid output queue size '" + strconv.Itoa(config.OutputQueue) + "', using " + strconv.Itoa(defaultOutputQueue))
+		config.OutputQueue = defaultOutputQueue
+	}
+	if config.TLSPort != 0 && !validPort(config.TLSPort) {
+		fmt.Println("invalid TLS port '" + strconv.Itoa(config.TLSPort) + "', disabling TLS")
+		config.TLSPort = 0
+	}
+	return config
+}
@@ -0,0 +1,376 @@
+/*
+containers.go contains containers: items which hold other items, such as bags and chests.
+
+An item is a container if its capacity, the number of items it can hold, is more than 0.
+Contained items have the location type ilItem, and are in their container's Items.
+Containers may be closed, and closed containers with a key may be locked, by a player carrying the key.
+
+Players put items in containers with 'put sword in chest', and get them out with 'get sword from chest'.
+Both ends are targets, so 'put all.coin in 2.bag' works; see target.go.
+*/
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxContainerDisplayDepth is how many levels of nested containers inventory shows the contents of.
+const maxContainerDisplayDepth = 3
+
+func (i *Item) IsContainer() bool {
+	return i.Capacity > 0
+}
+
+// containedIds returns the ids of the items in the container, in the order they were created.
+func containedIds(container *Item) []identifier {
+	ids := make([]identifier, 0, len(container.Items))
+	for id := range container.Items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// containerBrief returns the item's brief, followed by what it contains if it's an open container,
+// e.g. "a leather bag (containing a coin, a ring)". The contents mustn't be held by the caller.
+func containerBrief(item *Item, world *World, depth int) string {
+	if !item.IsContainer() {
+		return item.Brief()
+	}
+	if item.Closed {
+		return item.Brief() + " (closed)"
+	}
+	if len(item.Items) == 0 || depth >= maxContainerDisplayDepth {
+		return item.Brief()
+	}
+	var contents []string
+	for _, id := range containedIds(item) {
+		if it, exists := world.items.GetById(id); exists {
+			contents = append(contents, containerBrief(it, world, depth+1))
+		}
+	}
+	return item.Brief() + " (containing " + strings.Join(contents, ", ") + ")"
+}
+
+// containerContents describes what a player looking at the container sees inside it. The contents mustn't be held by the caller.
+func containerContents(container *Item, world *World) string {
+	if container.Closed {
+		return "It is closed."
+	}
+	if len(container.Items) == 0 {
+		return "It is empty."
+	}
+	s := "It contains:"
+	for _, id := range containedIds(container) {
+		if it, exists := world.items.GetById(id); exists {
+			s += "\r\n" + containerBrief(it, world, 1)
+		}
+	}
+	return s
+}
+
+// splitContainerArgs splits args such as 'all.coin in bag' at the last of the separator words,
+// into the items and the container.
+func splitContainerArgs(args []string, separators ...string) (string, string, bool) {
+	for i := len(args) - 1; i > 0; i-- {
+		for _, separator := range separators {
+			if strings.ToLower(args[i]) == separator && i < len(args)-1 {
+				return strings.Join(args[:i], " "), strings.Join(args[i+1:], " "), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// doWithContainer gets the player, their room, the things they're carrying and can see, the container the target means,
+// and the container's contents, and calls act. It tells the player if the target isn't a container, and doesn't call act.
+func doWithContainer(world *World, playerId identifier, t Target, act func(player *Player, room *Room, container *Item, got Got)) error {
+	var container *Item
+	getPlayer := func(got Got) (*ToGet, error) {
+		return PlayerGet(playerId), nil
+	}
+	getRoom := func(got Got) (*ToGet, error) {
+		player, ok := got.players[playerId]
+		if !ok {
+			return nil, fmt.Errorf("Error finding container for player %v: not returned from manager!", playerId)
+		}
+		return RoomGet(player.Room), nil
+	}
+	getThings := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room, ok := got.rooms[player.Room]
+		if !ok {
+			return nil, fmt.Errorf("Error finding container for player %v: room %v not returned from manager!", playerId, player.Room)
+		}
+		toGet := &ToGet{}
+		for _, things := range tsInventoryAndRoom.things(player, room) {
+			for id, itemType := range things {
+				switch itemType {
+				case piItem:
+					toGet.items = append(toGet.items, id)
+				case piNpc:
+					toGet.npcs = append(toGet.npcs, id)
+				}
+			}
+		}
+		return toGet, nil
+	}
+	getContents := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room := got.rooms[player.Room]
+		targets := ResolveTarget(t, tsInventoryAndRoom, player, room, got)
+		if len(targets) != 1 {
+			player.Write(tsInventoryAndRoom.NotFound())
+			return nil, nil
+		}
+		item, ok := got.items[targets[0]]
+		if !ok || !item.IsContainer() {
+			player.Write("That isn't a container.")
+			return nil, nil
+		}
+		container = item
+		return &ToGet{items: containedIds(container)}, nil
+	}
+	run := func(got Got) (*ToGet, error) {
+		if container == nil {
+			return nil, nil
+		}
+		player := got.players[playerId]
+		act(player, got.rooms[player.Room], container, got)
+		return nil, nil
+	}
+	return world.Do([]DoFunc{getPlayer, getRoom, getThings, getContents, run})
+}
+
+// put puts items the player is carrying into a container they're carrying or can see.
+func put(args []string, playerId identifier, world *World) {
+	itemString, containerString, ok := splitContainerArgs(args, "in", "into")
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "Usage: put item in container", "put called with invalid player")
+		return
+	}
+	itemTarget, ok := ParseTarget(itemString)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to put in it?", "put called with invalid player")
+		return
+	}
+	containerTarget, ok := ParseTarget(containerString)
+	if !ok || containerTarget.All {
+		tryPlayerWrite(playerId, world.players, "What do you want to put it in?", "put called with invalid player")
+		return
+	}
+	err := doWithContainer(world, playerId, containerTarget, func(player *Player, room *Room, container *Item, got Got) {
+		if container.Closed {
+			player.Write("The " + container.Name() + " is closed.")
+			return
+		}
+		targets := ResolveTarget(itemTarget, tsInventory, player, room, got)
+		if len(targets) == 0 {
+			player.Write(tsInventory.NotFound())
+			return
+		}
+		for _, itemId := range targets {
+			if itemId == container.Id() {
+				if !itemTarget.All {
+					player.Write("You can't put the " + container.Name() + " in itself.")
+				}
+				continue
+			}
+			item, ok := got.items[itemId]
+			if !ok {
+				if !itemTarget.All {
+					player.Write("You can't put that in anything.")
+				}
+				continue
+			}
+			if len(container.Items) >= container.Capacity {
+				player.Write("The " + container.Name() + " is full.")
+				return
+			}
+			delete(player.Items, itemId)
+			container.Items[itemId] = true
+			item.Location = container.Id()
+			item.LocationType = ilItem
+			player.Write("You put " + item.Brief() + " in " + container.Brief() + ".")
+			room.Write(ToProper(player.Name())+" puts "+item.Brief()+" in "+container.Brief()+".", *world.players, player.Name())
+		}
+	})
+	if err != nil {
+		fmt.Printf("put error: %v\n", err)
+	}
+}
+
+// getFrom takes items out of a container the player is carrying or can see, for 'get item from container'.
+func getFrom(itemString string, containerString string, playerId identifier, world *World) {
+	itemTarget, ok := ParseTarget(itemString)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to get from it?", "get called with invalid player")
+		return
+	}
+	containerTarget, ok := ParseTarget(containerString)
+	if !ok || containerTarget.All {
+		tryPlayerWrite(playerId, world.players, "What do you want to get it from?", "get called with invalid player")
+		return
+	}
+	err := doWithContainer(world, playerId, containerTarget, func(player *Player, room *Room, container *Item, got Got) {
+		if container.Closed {
+			player.Write("The " + container.Name() + " is closed.")
+			return
+		}
+		targets := resolveAmong(itemTarget, containedIds(container), got)
+		if len(targets) == 0 {
+			player.Write("The " + container.Name() + " doesn't contain that.")
+			return
+		}
+		for _, itemId := range targets {
+			item, ok := got.items[itemId]
+			if !ok {
+				continue
+			}
+			delete(container.Items, itemId)
+			player.Items[itemId] = piItem
+			item.Location = player.Id()
+			item.LocationType = ilPlayer
+			player.Write("You get " + item.Brief() + " from " + container.Brief() + ".")
+			room.Write(ToProper(player.Name())+" gets "+item.Brief()+" from "+container.Brief()+".", *world.players, player.Name())
+		}
+	})
+	if err != nil {
+		fmt.Printf("get error: %v\n", err)
+	}
+}
+
+// changeContainer resolves the container the player means, and calls change with it.
+func changeContainer(args []string, playerId identifier, world *World, verb string, change func(player *Player, container *Item)) {
+	target, ok := ParseTarget(strings.Join(args, " "))
+	if !ok || target.All {
+		tryPlayerWrite(playerId, world.players, "What do you want to "+verb+"?", verb+" called with invalid player")
+		return
+	}
+	err := doWithTarget(world, playerId, tsInventoryAndRoom, target, func(player *Player, room *Room, targets []identifier, got Got) {
+		if len(targets) == 0 {
+			player.Write(tsInventoryAndRoom.NotFound())
+			return
+		}
+		container, ok := got.items[targets[0]]
+		if !ok || !container.IsContainer() {
+			player.Write("You can't " + verb + " that.")
+			return
+		}
+		change(player, container)
+	})
+	if err != nil {
+		fmt.Printf("%s error: %v\n", verb, err)
+	}
+}
+
+func openContainer(args []string, playerId identifier, world *World) {
+	changeContainer(args, playerId, world, "open", func(player *Player, container *Item) {
+		switch {
+		case !container.Closed:
+			player.Write("The " + container.Name() + " is already open.")
+		case container.Locked:
+			player.Write("The " + container.Name() + " is locked.")
+		default:
+			container.Closed = false
+			player.Write("You open the " + container.Name() + ".")
+		}
+	})
+}
+
+func closeContainer(args []string, playerId identifier, world *World) {
+	changeContainer(args, playerId, world, "close", func(player *Player, container *Item) {
+		if container.Closed {
+			player.Write("The " + container.Name() + " is already closed.")
+			return
+		}
+		container.Closed = true
+		player.Write("You close the " + container.Name() + ".")
+	})
+}
+
+func lockContainer(args []string, playerId identifier, world *World) {
+	changeContainer(args, playerId, world, "lock", func(player *Player, container *Item) {
+		switch {
+		case container.Key == invalidIdentifier:
+			player.Write("The " + container.Name() + " has no lock.")
+		case container.Locked:
+			player.Write("The " + container.Name() + " is already locked.")
+		case !container.Closed:
+			player.Write("You have to close the " + container.Name() + " first.")
+		case !hasItem(player, container.Key):
+			player.Write("You don't have the key.")
+		default:
+			container.Locked = true
+			player.Write("You lock the " + container.Name() + ".")
+		}
+	})
+}
+
+func unlockContainer(args []string, playerId identifier, world *World) {
+	changeContainer(args, playerId, world, "unlock", func(player *Player, container *Item) {
+		switch {
+		case container.Key == invalidIdentifier:
+			player.Write("The " + container.Name() + " has no lock.")
+		case !container.Locked:
+			player.Write("The " + container.Name() + " isn't locked.")
+		case !hasItem(player, container.Key):
+			player.Write("You don't have the key.")
+		default:
+			container.Locked = false
+			player.Write("You unlock the " + container.Name() + ".")
+		}
+	})
+}
+
+// hasItem returns whether the player is carrying the item itself, rather than in a container.
+func hasItem(player *Player, id identifier) bool {
+	itemType, ok := player.Items[id]
+	return ok && itemType == piItem
+}
+
+// containerItem makes an item a container which holds capacity items, optionally locked by the key item,
+// e.g. 'containeritem 42 10 43'. A capacity of 0 makes it an ordinary item again.
+func containerItem(args []string, playerId identifier, world *World) {
+	itemInt, err := strconv.Atoi(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid identifier to make a container.", "containerItem called with invalid id")
+		return
+	}
+	capacity, err := strconv.Atoi(args[1])
+	if err != nil || capacity < 0 {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid capacity.", "containerItem called with invalid capacity")
+		return
+	}
+	key := invalidIdentifier
+	if len(args) > 2 {
+		keyInt, err := strconv.Atoi(args[2])
+		if err != nil {
+			tryPlayerWrite(playerId, world.players, "Please provide a valid key identifier.", "containerItem called with invalid key")
+			return
+		}
+		key = identifier(keyInt)
+	}
+	if !world.items.ChangeById(identifier(itemInt), func(i *Item) {
+		if capacity < len(i.Items) {
+			tryPlayerWrite(playerId, world.players, "The "+i.Name()+" already holds "+strconv.Itoa(len(i.Items))+" items.", "containerItem failed and player disappeared")
+			return
+		}
+		i.Capacity = capacity
+		i.Key = key
+		if key == invalidIdentifier {
+			i.Locked = false
+		}
+		if capacity == 0 {
+			i.Closed = false
+			tryPlayerWrite(playerId, world.players, "The "+i.Name()+" is no longer a container.", "containerItem succeeded but player disappeared")
+			return
+		}
+		tryPlayerWrite(playerId, world.players, "The "+i.Name()+" can now hold "+strconv.Itoa(capacity)+" items.", "containerItem succeeded but player disappeared")
+	}) {
+		tryPlayerWrite(playerId, world.players, "There is no item with that identifier.", "containerItem called with invalid id")
+	}
+}
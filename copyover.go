@@ -0,0 +1,162 @@
+/*
+copyover.go restores player sessions after a copyover.
+
+A copyover (see copyover_linux.go) replaces the running server with a new binary, without closing players' connections.
+Before it execs, the old server pauses the world (see worldPause), saves everything, and writes each session's file descriptor, player and telnet state to
+the copyover file. When the new server starts listening, it reads the file, and puts each player straight back into the
+game, without logging in again. The new server knows it was started by a copyover from copyoverEnv; any other start
+removes the file unread.
+
+Only plain telnet sessions survive a copyover. TLS and WebSocket sessions have state which can't be handed over, and reconnect.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+)
+
+const copyoverFile = "./gomud.copyover"
+
+// copyoverEnv is set in the environment of a server exec'd by a copyover. Only such a server restores the copyover file:
+// a file left by a server which crashed before restoring it has descriptor numbers which now mean something else.
+const copyoverEnv = "GOMUD_COPYOVER"
+
+// worldPause is held for reading by whatever changes the world on its own: players' commands, NPC scripts,
+// logins and link-dead reaping. A copyover holds it for writing, so nothing changes between its final save and the exec.
+var worldPause sync.RWMutex
+
+// waitWhilePaused returns once no copyover has the world paused, e.g. so listeners don't hand over connections during one.
+func waitWhilePaused() {
+	worldPause.RLock()
+	worldPause.RUnlock()
+}
+
+type copyoverState struct {
+	Sessions []copyoverSession `json:"sessions"`
+}
+
+type copyoverSession struct {
+	Fd     uintptr        `json:"fd"`
+	Player identifier     `json:"player"`
+	Name   string         `json:"name"`
+	Telnet telnetSnapshot `json:"telnet"`
+}
+
+// telnetSnapshot is the negotiated state of a TelnetConn, so it can be restored without negotiating again.
+type telnetSnapshot struct {
+	Local        []byte         `json:"local"`  // options enabled on our side
+	Remote       []byte         `json:"remote"` // options enabled on the client's side
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	GMCPClient   string         `json:"gmcp_client"`
+	GMCPVersion  string         `json:"gmcp_version"`
+	GMCPSupports map[string]int `json:"gmcp_supports"`
+	MSDPReported []string       `json:"msdp_reported"`
+	Compressed   bool           `json:"compressed"`
+}
+
+func (t *TelnetConn) snapshot() telnetSnapshot {
+	snapshot := telnetSnapshot{Compressed: t.Compressed()}
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	for option, state := range t.options {
+		if state.us == qYes {
+			snapshot.Local = append(snapshot.Local, byte(option))
+		}
+		if state.him == qYes {
+			snapshot.Remote = append(snapshot.Remote, byte(option))
+		}
+	}
+	snapshot.Width = t.width
+	snapshot.Height = t.height
+	snapshot.GMCPClient = t.gmcp.client
+	snapshot.GMCPVersion = t.gmcp.version
+	snapshot.GMCPSupports = t.gmcp.supports
+	for name := range t.msdp.reported {
+		snapshot.MSDPReported = append(snapshot.MSDPReported, name)
+	}
+	return snapshot
+}
+
+// restore sets the TelnetConn to the snapshot's state, and restarts compression if it was on.
+func (t *TelnetConn) restore(snapshot telnetSnapshot) {
+	t.optionsMutex.Lock()
+	for _, option := range snapshot.Local {
+		t.options[option].us = qYes
+	}
+	for _, option := range snapshot.Remote {
+		t.options[option].him = qYes
+	}
+	t.width = snapshot.Width
+	t.height = snapshot.Height
+	t.gmcp.client = snapshot.GMCPClient
+	t.gmcp.version = snapshot.GMCPVersion
+	t.gmcp.supports = snapshot.GMCPSupports
+	if len(snapshot.MSDPReported) > 0 {
+		t.msdp.reported = make(map[string]bool)
+		for _, name := range snapshot.MSDPReported {
+			t.msdp.reported[name] = true
+		}
+	}
+	t.optionsMutex.Unlock()
+	if snapshot.Compressed {
+		t.startCompression()
+	}
+}
+
+// restoreCopyover restores the sessions saved by a copyover, if the server was started by one.
+func restoreCopyover(world *World, config Config) {
+	if os.Getenv(copyoverEnv) == "" {
+		if err := os.Remove(copyoverFile); err == nil {
+			fmt.Println("copyover: removed a stale copyover file, from a server which didn't restore it")
+		}
+		return
+	}
+	os.Unsetenv(copyoverEnv) // so a server this one execs some other way doesn't restore
+
+	data, err := ioutil.ReadFile(copyoverFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("copyover error: " + err.Error())
+		}
+		return
+	}
+	os.Remove(copyoverFile)
+
+	var state copyoverState
+	if err := json.Unmarshal(data, &state); err != nil {
+		fmt.Println("copyover error: " + err.Error())
+		return
+	}
+	for _, saved := range state.Sessions {
+		f := os.NewFile(saved.Fd, "copyover "+saved.Name)
+		conn, err := net.FileConn(f)
+		f.Close() // FileConn dups the descriptor
+		if err != nil {
+			fmt.Println("copyover error: " + saved.Name + " " + err.Error())
+			continue
+		}
+		t := NewTelnetConn(conn)
+		supportTelnetOptions(t, world)
+		t.restore(saved.Telnet)
+		s := NewSession(t, config)
+
+		player, exists := world.players.GetByName(saved.Name)
+		if !exists && tryLoadPlayer(saved.Name, world) {
+			player, exists = world.players.GetByName(saved.Name)
+		}
+		if !exists || player.Id() != saved.Player || !takeOverPlayer(world, player.Id(), s) {
+			s.Write([]byte("Your character couldn't be restored after the restart. "))
+			go handleLogin(*world, s)
+			continue
+		}
+		s.Write([]byte("\r\nThe world shimmers, and settles again.\r\n"))
+		go handlePlayer(*world, player.Id())
+	}
+	fmt.Printf("copyover restored %d sessions\n", len(state.Sessions))
+}
@@ -0,0 +1,119 @@
+/*
+copyover_linux.go replaces the running server with a new binary, keeping players connected.
+
+The new binary is whatever is at the running binary's path, so deploying is copying the new binary
+over the old one, and typing copyover. The path comes from os.Executable, with symlinks resolved,
+so replace the binary itself, rather than pointing a symlink somewhere else.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// copyoverFlushTimeout is how long to wait for a session's queued output before the exec.
+const copyoverFlushTimeout = 2 * time.Second
+
+// inheritableFd returns a duplicate of the connection's descriptor, which the new server inherits.
+// Go opens everything close-on-exec, but dup doesn't copy the flag.
+// Unlike TCPConn.File, this leaves the connection non-blocking, so it keeps working if the exec fails.
+func inheritableFd(tcp *net.TCPConn) (int, error) {
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(connFd uintptr) {
+		fd, dupErr = syscall.Dup(int(connFd))
+	})
+	if err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
+
+func copyover(args []string, playerId identifier, world *World) {
+	exe, err := os.Executable()
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Copyover failed: "+err.Error(), "copyover called with invalid player")
+		return
+	}
+
+	go runCopyover(world, exe) // it waits for worldPause, which this command holds
+}
+
+// runCopyover pauses the world, saves it, and execs the new server. It only returns if the exec fails.
+func runCopyover(world *World, exe string) {
+	for _, s := range Sessions() {
+		s.Write([]byte("\r\nThe world pauses for a moment while it is rebuilt. Please wait.\r\n"))
+	}
+	// stop commands, NPC scripts, logins and new connections changing anything after the save
+	worldPause.Lock()
+	defer worldPause.Unlock()
+	flushDb(world)
+
+	var state copyoverState
+	var fds []int                // inherited by the new server, and closed if the exec fails
+	var compressed []*TelnetConn // restarted if the exec fails
+	for _, s := range Sessions() {
+		id := s.Player()
+		t, isTelnet := s.Unwrap().(*TelnetConn)
+		var tcp *net.TCPConn
+		if isTelnet {
+			tcp, isTelnet = t.Conn.(*net.TCPConn)
+		}
+		player, exists := world.players.GetById(id)
+		if id == invalidIdentifier || !exists || player.connection != s || !isTelnet {
+			s.Write([]byte("Your connection can't be kept through the restart. Please reconnect in a moment.\r\n"))
+			s.Flush(copyoverFlushTimeout)
+			continue
+		}
+
+		s.Flush(copyoverFlushTimeout)
+		snapshot := t.snapshot()
+		t.stopCompression() // the new server starts a new stream
+		if snapshot.Compressed {
+			compressed = append(compressed, t)
+		}
+		fd, err := inheritableFd(tcp)
+		if err != nil {
+			fmt.Println("copyover error: " + player.Name() + " " + err.Error())
+			continue
+		}
+		fds = append(fds, fd)
+		state.Sessions = append(state.Sessions, copyoverSession{
+			Fd:     uintptr(fd),
+			Player: id,
+			Name:   player.Name(),
+			Telnet: snapshot,
+		})
+	}
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = ioutil.WriteFile(copyoverFile, data, 0600)
+	}
+	if err == nil {
+		fmt.Printf("copyover with %d sessions\n", len(state.Sessions))
+		err = syscall.Exec(exe, os.Args, append(os.Environ(), copyoverEnv+"=1")) // only returns if it fails
+	}
+
+	fmt.Println("copyover error: " + err.Error())
+	os.Remove(copyoverFile)
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+	for _, t := range compressed {
+		t.startCompression()
+	}
+	for _, s := range Sessions() {
+		s.Write([]byte("\r\nThe world failed to rebuild, and carries on as it was.\r\n"))
+	}
+}
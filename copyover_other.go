@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+/*
+copyover_other.go is the copyover command on systems without copyover support.
+*/
+package main
+
+func copyover(args []string, playerId identifier, world *World) {
+	tryPlayerWrite(playerId, world.players, "Copyover is only supported on Linux.", "copyover called with invalid player")
+}
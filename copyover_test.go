@@ -0,0 +1,26 @@
+/*
+copyover_test.go tests that only a server started by a copyover restores the copyover file.
+*/
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRestoreCopyoverIgnoresStaleFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(copyoverEnv, "")
+	// descriptor 0 would be closed if the file were restored; the nil World would crash on the session
+	if err := ioutil.WriteFile(copyoverFile, []byte(`{"sessions":[{"fd":0,"player":1,"name":"bob"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restoreCopyover(nil, Config{})
+	if fileExists(copyoverFile) {
+		t.Errorf("the stale copyover file wasn't removed")
+	}
+	if _, err := os.Stdin.Stat(); err != nil {
+		t.Errorf("stdin was closed: %v", err)
+	}
+}
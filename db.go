@@ -14,6 +14,7 @@ import (
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"strconv"
+	"strings"
 )
 
 /// @todo ? move this to a utils file ?
@@ -30,9 +31,17 @@ func checkSchema(db *sql.DB) {
 		//		`create table if not exists containers (id integer, )`
 		`create table if not exists rooms (id integer, name text, description text);`,
 		`create table if not exists room_exits (id integer, link integer, direction integer);`,
-		`create table if not exists items (id integer, name text, brief text, location integer, location_type integer);`,
-		`create table if not exists npcs (id integer, name text, brief text, dna text, location integer, location_type integer);`,
-		`create table if not exists players (id integer, name text, salt text, pass text, level integer, health integer, mana integer, room_id integer);`,
+		`create table if not exists room_extras (id integer, keywords text, description text);`,
+		`create table if not exists npc_hooks (id integer, event text, script text);`,
+		`create table if not exists channels (name text primary key, role integer, color text);`,
+		`create table if not exists player_channels (player_id integer, channel text, joined integer, muted integer);`,
+		`create table if not exists socials (name text primary key, actor text, room text, actor_target text, target text, room_target text, actor_self text, room_self text);`,
+		`create table if not exists items (id integer, name text, brief text, location integer, location_type integer, keywords text default '', description text default '', capacity integer default 0, closed integer default 0, locked integer default 0, key_id integer default -1);`,
+		`create table if not exists npcs (id integer, name text, brief text, dna text, location integer, location_type integer, description text default '');`,
+		`create table if not exists players (id integer, name text, salt text, pass text, level integer, health integer, mana integer, room_id integer, wrap integer default 0, role integer default 0, scrypt_n integer default 16384, scrypt_r integer default 8, scrypt_p integer default 1, reset_token text default '', account_id integer default 0, description text default '');`,
+		`create table if not exists accounts (id integer primary key, login text unique, salt text, pass text, scrypt_n integer, scrypt_r integer, scrypt_p integer, reset_token text default '', settings text default '{}', created integer, last_login integer);`,
+		`create table if not exists player_aliases (player_id integer, name text, expansion text);`,
+		`create table if not exists site_bans (cidr text primary key, reason text, expires integer, banned_by text);`,
 	}
 
 	for _, sql := range sqls {
@@ -42,6 +51,35 @@ func checkSchema(db *sql.DB) {
 			fmt.Println(err)
 		}
 	}
+
+	// players' salt, pass, scrypt_* and reset_token columns are from before accounts, and are only read by migrateAccounts.
+
+	// columns added since their table was first created, for databases created by older versions.
+	// If the column already exists, sqlite returns a duplicate column error, which is expected.
+	columns := []string{
+		`alter table players add column wrap integer default 0;`,
+		`alter table players add column role integer default 0;`,
+		`alter table players add column scrypt_n integer default 16384;`,
+		`alter table players add column scrypt_r integer default 8;`,
+		`alter table players add column scrypt_p integer default 1;`,
+		`alter table players add column reset_token text default '';`,
+		`alter table players add column account_id integer default 0;`,
+		`alter table items add column keywords text default '';`,
+		`alter table items add column description text default '';`,
+		`alter table items add column capacity integer default 0;`,
+		`alter table items add column closed integer default 0;`,
+		`alter table items add column locked integer default 0;`,
+		`alter table items add column key_id integer default -1;`,
+		`alter table npcs add column description text default '';`,
+		`alter table players add column description text default '';`,
+	}
+	for _, sql := range columns {
+		_, err := db.Exec(sql)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			fmt.Print("dberr checkSchema ")
+			fmt.Println(err)
+		}
+	}
 }
 
 func loadRooms(db *sql.DB, rooms RoomManager) {
@@ -64,6 +102,7 @@ func loadRooms(db *sql.DB, rooms RoomManager) {
 			Exits:       make(map[Direction]identifier),
 			Players:     make(map[identifier]bool),
 			Items:       make(map[identifier]PlayerItemType),
+			Extras:      make(map[string]string),
 		}
 		exitRows, err := db.Query(`select link, direction from room_exits where id = ` + room.id.String() + `;`)
 		if err != nil {
@@ -77,12 +116,51 @@ func loadRooms(db *sql.DB, rooms RoomManager) {
 			exitRows.Scan(&link, &dir)
 			room.Exits[Direction(dir)] = identifier(link)
 		}
+		exitRows.Close()
+		extraRows, err := db.Query(`select keywords, description from room_extras where id = ?;`, room.id)
+		if err != nil {
+			fmt.Print("dberr loadRooms ")
+			fmt.Println(err)
+			continue
+		}
+		for extraRows.Next() {
+			var keywords, description string
+			extraRows.Scan(&keywords, &description)
+			room.Extras[keywords] = description
+		}
+		extraRows.Close()
 		ThingManager(rooms).DbAdd(&room)
 	}
 }
 
+// npcColumns are the columns scanNpc scans.
+const npcColumns = `id, name, brief, dna, location, location_type, description`
+
+// scanNpc scans the NPC in the current row, and loads its hooks.
+func scanNpc(rows *sql.Rows, db *sql.DB) (*Npc, bool) {
+	npc := Npc{
+		Sleeping: false,
+		Items:    make(map[identifier]bool),
+		Hooks:    make(map[string]string),
+	}
+	rows.Scan(&npc.id, &npc.name, &npc.Brief, &npc.Dna, &npc.Location, &npc.LocationType, &npc.Description)
+	hookRows, err := db.Query(`select event, script from npc_hooks where id = ?;`, npc.id)
+	if err != nil {
+		fmt.Print("dberr scanNpc ")
+		fmt.Println(err)
+		return nil, false
+	}
+	for hookRows.Next() {
+		var event, script string
+		hookRows.Scan(&event, &script)
+		npc.Hooks[event] = script
+	}
+	hookRows.Close()
+	return &npc, true
+}
+
 func loadNpcs(db *sql.DB, world *World) {
-	rows, err := db.Query(`select id, name, brief, dna, location, location_type from npcs;`)
+	rows, err := db.Query(`select ` + npcColumns + ` from npcs;`)
 	if err != nil {
 		fmt.Print("dberr loadNpcs ")
 		fmt.Println(err)
@@ -95,11 +173,10 @@ func loadNpcs(db *sql.DB, world *World) {
 		ownee identifier
 	}
 	for rows.Next() {
-		npc := Npc{
-			Sleeping: false,
-			Items:    make(map[identifier]bool),
+		npc, ok := scanNpc(rows, db)
+		if !ok {
+			continue
 		}
-		rows.Scan(&npc.id, &npc.name, &npc.Brief, &npc.Dna, &npc.Location, &npc.LocationType)
 		switch npc.LocationType {
 		case ilRoom:
 			success := world.rooms.ChangeById(npc.id, func(loc *Room) {
@@ -123,7 +200,7 @@ func loadNpcs(db *sql.DB, world *World) {
 		default:
 			fmt.Println("loadNpcs got invalid item")
 		}
-		ThingManager(*world.npcs).DbAdd(&npc)
+		ThingManager(*world.npcs).DbAdd(npc)
 		for _, pair := range owners {
 			world.npcs.ChangeById(pair.owner, func(loc *Npc) {
 				loc.Items[pair.ownee] = true
@@ -132,11 +209,16 @@ func loadNpcs(db *sql.DB, world *World) {
 	}
 }
 
-func loadItem(rows *sql.Rows, world *World) {
+// itemColumns are the columns loadItem scans.
+const itemColumns = `id, name, brief, location, location_type, keywords, description, capacity, closed, locked, key_id`
+
+// loadItem loads the item in the current row, and puts it in its location, which must already be loaded.
+// It returns the item's id, and false if its location wasn't loaded.
+func loadItem(rows *sql.Rows, world *World) (identifier, bool) {
 	item := Item{
 		Items: make(map[identifier]bool),
 	}
-	rows.Scan(&item.id, &item.name, &item.brief, &item.Location, &item.LocationType)
+	rows.Scan(&item.id, &item.name, &item.brief, &item.Location, &item.LocationType, &item.keywords, &item.description, &item.Capacity, &item.Closed, &item.Locked, &item.Key)
 	fmt.Println("loading " + item.id.String())
 	switch item.LocationType {
 	case ilRoom:
@@ -145,7 +227,7 @@ func loadItem(rows *sql.Rows, world *World) {
 		})
 		if !success {
 			fmt.Println("loaditem failed on room for " + item.id.String())
-			return
+			return item.id, false
 		}
 	case ilPlayer:
 		success := world.players.ChangeById(item.Location, func(loc *Player) {
@@ -153,7 +235,7 @@ func loadItem(rows *sql.Rows, world *World) {
 		})
 		if !success {
 			fmt.Println("loaditem failed on player for " + item.id.String())
-			return
+			return item.id, false
 		}
 	case ilNpc:
 		success := world.npcs.ChangeById(item.Location, func(loc *Npc) {
@@ -161,32 +243,126 @@ func loadItem(rows *sql.Rows, world *World) {
 		})
 		if !success {
 			fmt.Println("loaditem failed on npc for " + item.id.String())
-			return
+			return item.id, false
+		}
+	case ilItem:
+		success := world.items.ChangeById(item.Location, func(loc *Item) {
+			loc.Items[item.id] = true
+		})
+		if !success {
+			fmt.Println("loaditem failed on container for " + item.id.String())
+			return item.id, false
 		}
 	}
 	ThingManager(*world.items).DbAdd(&item)
+	return item.id, true
+}
+
+// loadCarriedNpcs loads the NPCs the player carries, and the NPCs and items they carry, and so on.
+// Carried NPCs are loaded with their player, like the player's items, because they're unloaded with them.
+func loadCarriedNpcs(db *sql.DB, world *World, playerId identifier) {
+	carriers := []identifier{playerId}
+	carrierType := ilPlayer
+	for len(carriers) > 0 {
+		var npcs []identifier
+		for _, carrier := range carriers {
+			rows, err := db.Query(`select `+npcColumns+` from npcs where location_type = ? and location = ?;`, carrierType, carrier)
+			if err != nil {
+				fmt.Print("dberr loadCarriedNpcs ")
+				fmt.Println(err)
+				continue
+			}
+			var loaded []*Npc
+			for rows.Next() {
+				if npc, ok := scanNpc(rows, db); ok {
+					loaded = append(loaded, npc)
+				}
+			}
+			rows.Close()
+			for _, npc := range loaded {
+				ThingManager(*world.npcs).DbAdd(npc)
+				id := npc.Id()
+				if carrierType == ilPlayer {
+					world.players.ChangeById(carrier, func(p *Player) {
+						p.Items[id] = piNpc
+					})
+				} else {
+					world.npcs.ChangeById(carrier, func(n *Npc) {
+						n.Items[id] = true
+					})
+				}
+				npcs = append(npcs, id)
+			}
+		}
+
+		var items []identifier
+		for _, npc := range npcs {
+			rows, err := db.Query(`select `+itemColumns+` from items where location_type = ? and location = ?;`, ilNpc, npc)
+			if err != nil {
+				fmt.Print("dberr loadCarriedNpcs ")
+				fmt.Println(err)
+				continue
+			}
+			for rows.Next() {
+				if id, ok := loadItem(rows, world); ok {
+					items = append(items, id)
+				}
+			}
+			rows.Close()
+		}
+		loadContents(db, world, items)
+
+		carriers = npcs
+		carrierType = ilNpc
+	}
+}
+
+// loadContents loads the items in the containers, and the items in those, and so on.
+func loadContents(db *sql.DB, world *World, containers []identifier) {
+	for len(containers) > 0 {
+		var contents []identifier
+		for _, container := range containers {
+			rows, err := db.Query(`select `+itemColumns+` from items where location_type = ? and location = ?;`, ilItem, container)
+			if err != nil {
+				fmt.Print("dberr loadContents ")
+				fmt.Println(err)
+				continue
+			}
+			for rows.Next() {
+				if id, ok := loadItem(rows, world); ok {
+					contents = append(contents, id)
+				}
+			}
+			rows.Close()
+		}
+		containers = contents
+	}
 }
 
 func loadItems(db *sql.DB, world *World) {
-	rows, err := db.Query(`select id, name, brief, location, location_type from items;`)
+	rows, err := db.Query(`select `+itemColumns+` from items where location_type != ?;`, ilItem)
 	if err != nil {
 		fmt.Print("dberr loadItems ")
 		fmt.Println(err)
 		return
 	}
-	defer rows.Close()
+	var loaded []identifier
 	for rows.Next() {
-		loadItem(rows, world)
+		if id, ok := loadItem(rows, world); ok {
+			loaded = append(loaded, id)
+		}
 	}
+	rows.Close()
+	loadContents(db, world, loaded)
 }
 
 func itemSaver(db *sql.DB, items ItemManager) {
-	addStmt, err := db.Prepare(`insert into items (id, name, brief, location, location_type) values (?,?,?,?,?);`)
+	addStmt, err := db.Prepare(`insert into items (id, name, brief, location, location_type, keywords, description, capacity, closed, locked, key_id) values (?,?,?,?,?,?,?,?,?,?,?);`)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	changeStmt, err := db.Prepare(`update items set name = ?, brief = ?, location = ?, location_type = ? where id = ?;`)
+	changeStmt, err := db.Prepare(`update items set name = ?, brief = ?, location = ?, location_type = ?, keywords = ?, description = ?, capacity = ?, closed = ?, locked = ?, key_id = ? where id = ?;`)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -196,56 +372,61 @@ func itemSaver(db *sql.DB, items ItemManager) {
 		fmt.Println(err)
 		return
 	}
-	saver := ThingManager(items).saver
-	for {
-		select {
-		case t := <-saver.add:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			stmt := tx.Stmt(addStmt)
-
-			item := t.(*Item)
-			stmt.Exec(item.id, item.name, item.brief, int(item.Location), int(item.LocationType))
-			stmt.Close()
-			doCommit <- tx
-		case t := <-saver.change:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			stmt := tx.Stmt(changeStmt)
-
-			item := t.(*Item)
-			stmt.Exec(item.name, item.brief, int(item.Location), int(item.LocationType), int(item.id))
-			stmt.Close()
-			doCommit <- tx
-		case id := <-saver.del:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			stmt := tx.Stmt(delStmt)
+	add := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(addStmt)
+
+		item := t.(*Item)
+		if _, err := stmt.Exec(item.id, item.name, item.brief, int(item.Location), int(item.LocationType), item.keywords, item.description, item.Capacity, item.Closed, item.Locked, int(item.Key)); err != nil {
+			dbWriteError(err)
+		}
+		stmt.Close()
+		doCommit <- tx
+	}
+	change := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(changeStmt)
+
+		item := t.(*Item)
+		if _, err := stmt.Exec(item.name, item.brief, int(item.Location), int(item.LocationType), item.keywords, item.description, item.Capacity, item.Closed, item.Locked, int(item.Key), int(item.id)); err != nil {
+			dbWriteError(err)
+		}
+		stmt.Close()
+		doCommit <- tx
+	}
+	del := func(id identifier) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(delStmt)
 
-			stmt.Exec(id)
-			stmt.Close();
-			doCommit <- tx
+		if _, err := stmt.Exec(id); err != nil {
+			dbWriteError(err)
 		}
+		stmt.Close();
+		doCommit <- tx
 	}
+	runSaver(ThingManager(items).saver, add, change, del)
 }
 
 func npcSaver(db *sql.DB, npcs NpcManager) {
-	addStmt, err := db.Prepare(`insert into npcs (id, name, brief, dna, location, location_type) values (?,?,?,?,?,?);`)
+	addStmt, err := db.Prepare(`insert into npcs (id, name, brief, dna, location, location_type, description) values (?,?,?,?,?,?,?);`)
 	if err != nil {
 		fmt.Print("dberr npcSaver 0 ")
 		fmt.Println(err)
 		return
 	}
-	changeStmt, err := db.Prepare(`update npcs set name = ?, brief = ?, dna = ?, location = ?, location_type = ? where id = ?;`)
+	changeStmt, err := db.Prepare(`update npcs set name = ?, brief = ?, dna = ?, location = ?, location_type = ?, description = ? where id = ?;`)
 	if err != nil {
 		fmt.Print("dberr npcSaver 1 ")
 		fmt.Println(err)
@@ -257,54 +438,95 @@ func npcSaver(db *sql.DB, npcs NpcManager) {
 		fmt.Println(err)
 		return
 	}
-	saver := ThingManager(npcs).saver
-	for {
-		select {
-		case t := <-saver.add:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			stmt := tx.Stmt(addStmt)
-
-			npc := t.(*Npc)
-			stmt.Exec(npc.id, npc.name, npc.Brief, npc.Dna, npc.Location, npc.LocationType)
-			stmt.Close();
-			doCommit <- tx
-		case t := <-saver.change:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
+	addHookStmt, err := db.Prepare(`insert into npc_hooks (id, event, script) values (?,?,?);`)
+	if err != nil {
+		fmt.Print("dberr npcSaver 3 ")
+		fmt.Println(err)
+		return
+	}
+	delHooksStmt, err := db.Prepare(`delete from npc_hooks where id = ?;`)
+	if err != nil {
+		fmt.Print("dberr npcSaver 4 ")
+		fmt.Println(err)
+		return
+	}
+	add := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(addStmt)
+		stmtHook := tx.Stmt(addHookStmt)
+
+		npc := t.(*Npc)
+		if _, err := stmt.Exec(npc.id, npc.name, npc.Brief, npc.Dna, npc.Location, npc.LocationType, npc.Description); err != nil {
+			dbWriteError(err)
+		}
+		for event, script := range npc.Hooks {
+			if _, err := stmtHook.Exec(npc.id, event, script); err != nil {
+				dbWriteError(err)
 			}
-			stmt := tx.Stmt(changeStmt)
-
-			npc := t.(*Npc)
-			stmt.Exec(npc.name, npc.Brief, npc.Dna, npc.Location, npc.LocationType, npc.id)
-			stmt.Close();
-			doCommit <- tx
-		case id := <-saver.del:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
+		}
+		stmt.Close();
+		stmtHook.Close()
+		doCommit <- tx
+	}
+	change := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(changeStmt)
+		stmtAddHook := tx.Stmt(addHookStmt)
+		stmtDelHooks := tx.Stmt(delHooksStmt)
+
+		npc := t.(*Npc)
+		if _, err := stmt.Exec(npc.name, npc.Brief, npc.Dna, npc.Location, npc.LocationType, npc.Description, npc.id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := stmtDelHooks.Exec(npc.id); err != nil {
+			dbWriteError(err)
+		}
+		for event, script := range npc.Hooks {
+			if _, err := stmtAddHook.Exec(npc.id, event, script); err != nil {
+				dbWriteError(err)
 			}
-			stmt := tx.Stmt(delStmt)
-			stmt.Exec(id)
-			stmt.Close();
-			doCommit <- tx
 		}
+		stmt.Close();
+		stmtAddHook.Close()
+		stmtDelHooks.Close()
+		doCommit <- tx
 	}
+	del := func(id identifier) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(delStmt)
+		stmtDelHooks := tx.Stmt(delHooksStmt)
+		if _, err := stmt.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := stmtDelHooks.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		stmt.Close();
+		stmtDelHooks.Close()
+		doCommit <- tx
+	}
+	runSaver(ThingManager(npcs).saver, add, change, del)
 }
 
 func playerSaver(db *sql.DB, players PlayerManager) {
-	addStmt, err := db.Prepare(`insert into players (id, name, salt, pass, level, health, mana, room_id) values (?,?,?,?,?,?,?,?);`)
+	addStmt, err := db.Prepare(`insert into players (id, name, account_id, level, health, mana, room_id, wrap, role, description) values (?,?,?,?,?,?,?,?,?,?);`)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	changeStmt, err := db.Prepare(`update players set name = ?, salt = ?, pass = ?, level = ?, health = ?, mana = ?, room_id = ? where id = ?;`)
+	changeStmt, err := db.Prepare(`update players set name = ?, account_id = ?, level = ?, health = ?, mana = ?, room_id = ?, wrap = ?, role = ?, description = ? where id = ?;`)
 	if err != nil {
 		fmt.Print("dberr playerSaver 1 ")
 		fmt.Println(err)
@@ -316,46 +538,147 @@ func playerSaver(db *sql.DB, players PlayerManager) {
 		fmt.Println(err)
 		return
 	}
-	saver := ThingManager(players).saver
-	for {
-		select {
-		case t := <-saver.add:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
+	addAliasStmt, err := db.Prepare(`insert into player_aliases (player_id, name, expansion) values (?,?,?);`)
+	if err != nil {
+		fmt.Print("dberr playerSaver 3 ")
+		fmt.Println(err)
+		return
+	}
+	delAliasesStmt, err := db.Prepare(`delete from player_aliases where player_id = ?;`)
+	if err != nil {
+		fmt.Print("dberr playerSaver 4 ")
+		fmt.Println(err)
+		return
+	}
+	addChannelStmt, err := db.Prepare(`insert into player_channels (player_id, channel, joined, muted) values (?,?,?,?);`)
+	if err != nil {
+		fmt.Print("dberr playerSaver 5 ")
+		fmt.Println(err)
+		return
+	}
+	delChannelsStmt, err := db.Prepare(`delete from player_channels where player_id = ?;`)
+	if err != nil {
+		fmt.Print("dberr playerSaver 6 ")
+		fmt.Println(err)
+		return
+	}
+	add := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(addStmt)
+		stmtAliases := tx.Stmt(addAliasStmt)
+		stmtChannels := tx.Stmt(addChannelStmt)
+
+		player := t.(*Player)
+		if _, err := stmt.Exec(player.id, player.name, player.account, player.level, player.health, player.mana, player.Room, player.Wrap, player.role, player.Description); err != nil {
+			dbWriteError(err)
+		}
+		for name, expansion := range player.Aliases {
+			if _, err := stmtAliases.Exec(player.id, name, expansion); err != nil {
+				dbWriteError(err)
 			}
-			stmt := tx.Stmt(addStmt)
-
-			player := t.(*Player)
-			stmt.Exec(player.id, player.name, string(player.passthesalt), string(player.pass), player.level, player.health, player.mana, player.Room)
-			stmt.Close()
-			doCommit <- tx
-		case t := <-saver.change:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				panic(err) // debug
-				return
+		}
+		for channel, membership := range player.Channels {
+			if _, err := stmtChannels.Exec(player.id, channel, membership.Joined, membership.Muted); err != nil {
+				dbWriteError(err)
 			}
-			stmt := tx.Stmt(changeStmt)
-
-			player := t.(*Player)
-			stmt.Exec(player.name, player.passthesalt, player.pass, player.level, player.health, player.mana, player.Room, player.id)
-			stmt.Close()
-			doCommit <- tx
-		case id := <-saver.del:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				panic(err) // debug
-				return
+		}
+		stmt.Close()
+		stmtAliases.Close()
+		stmtChannels.Close()
+		doCommit <- tx
+	}
+	change := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(changeStmt)
+		txAddAliases := tx.Stmt(addAliasStmt)
+		txDelAliases := tx.Stmt(delAliasesStmt)
+		txAddChannels := tx.Stmt(addChannelStmt)
+		txDelChannels := tx.Stmt(delChannelsStmt)
+
+		player := t.(*Player)
+		if _, err := stmt.Exec(player.name, player.account, player.level, player.health, player.mana, player.Room, player.Wrap, player.role, player.Description, player.id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := txDelAliases.Exec(player.id); err != nil {
+			dbWriteError(err)
+		}
+		for name, expansion := range player.Aliases {
+			if _, err := txAddAliases.Exec(player.id, name, expansion); err != nil {
+				dbWriteError(err)
+			}
+		}
+		if _, err := txDelChannels.Exec(player.id); err != nil {
+			dbWriteError(err)
+		}
+		for channel, membership := range player.Channels {
+			if _, err := txAddChannels.Exec(player.id, channel, membership.Joined, membership.Muted); err != nil {
+				dbWriteError(err)
 			}
-			stmt := tx.Stmt(delStmt)
+		}
+		stmt.Close()
+		txAddAliases.Close()
+		txDelAliases.Close()
+		txAddChannels.Close()
+		txDelChannels.Close()
+		doCommit <- tx
+	}
+	del := func(id identifier) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(delStmt)
+		txDelAliases := tx.Stmt(delAliasesStmt)
+		txDelChannels := tx.Stmt(delChannelsStmt)
 
-			stmt.Exec(id)
-			stmt.Close()
-			doCommit <- tx
+		if _, err := stmt.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := txDelAliases.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := txDelChannels.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		stmt.Close()
+		txDelAliases.Close()
+		txDelChannels.Close()
+		doCommit <- tx
+	}
+	runSaver(ThingManager(players).saver, add, change, del)
+}
+
+// runSaver calls add, change and del for each Thing sent to the saver, in the order they were sent, until the server stops.
+// A flush saves everything already sent before replying.
+func runSaver(saver ThingSaver, add func(Thing), change func(Thing), del func(identifier)) {
+	save := func(op saverOp) {
+		switch op.op {
+		case saveAdd:
+			add(op.thing)
+		case saveChange:
+			change(op.thing)
+		case saveDel:
+			del(op.id)
+		}
+	}
+	for {
+		select {
+		case op := <-saver.ops:
+			save(op)
+		case done := <-saver.flush:
+			for len(saver.ops) > 0 {
+				save(<-saver.ops)
+			}
+			done <- true
 		}
 	}
 }
@@ -364,12 +687,13 @@ func playerSaver(db *sql.DB, players PlayerManager) {
 func doTransaction(db *sql.DB, statement *sql.Stmt, args ...interface{}) {
 	tx, err := db.Begin()
 	if err != nil {
-		fmt.Println(err)
-		panic(err) // debug
+		dbWriteError(err)
 		return
 	}
 	stmt := tx.Stmt(statement)
-	stmt.Exec(args...)
+	if _, err := stmt.Exec(args...); err != nil {
+		dbWriteError(err)
+	}
 	stmt.Close()
 	doCommit <- tx
 }
@@ -400,62 +724,107 @@ func roomSaver(db *sql.DB, rooms RoomManager) {
 		fmt.Println(err)
 		return
 	}
-	saver := ThingManager(rooms).saver
-	for {
-		select {
-		case t := <-saver.add:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			stmt := tx.Stmt(addStmt)
-			stmtExits := tx.Stmt(addExitsStmt)
+	delExtrasStmt, err := db.Prepare(`delete from room_extras where id = ?;`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	addExtrasStmt, err := db.Prepare(`insert into room_extras (id, keywords, description) values (?,?,?);`)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	add := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		stmt := tx.Stmt(addStmt)
+		stmtExits := tx.Stmt(addExitsStmt)
+		stmtExtras := tx.Stmt(addExtrasStmt)
 
-			room := t.(*Room)
-			stmt.Exec(room.id, room.name, room.Description)
-			for dir, link := range room.Exits {
-				stmtExits.Exec(room.id, link, dir)
+		room := t.(*Room)
+		if _, err := stmt.Exec(room.id, room.name, room.Description); err != nil {
+			dbWriteError(err)
+		}
+		for dir, link := range room.Exits {
+			if _, err := stmtExits.Exec(room.id, link, dir); err != nil {
+				dbWriteError(err)
 			}
-			stmt.Close()
-			stmtExits.Close()
-			doCommit <- tx
-		case t := <-saver.change:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
+		}
+		for keywords, description := range room.Extras {
+			if _, err := stmtExtras.Exec(room.id, keywords, description); err != nil {
+				dbWriteError(err)
 			}
-			txChange := tx.Stmt(changeStmt)
-			txAddExits := tx.Stmt(addExitsStmt)
-			txDelExits := tx.Stmt(delExitsStmt)
-			room := t.(*Room)
-
-			txChange.Exec(room.name, room.Description, room.id)
-			txDelExits.Exec(room.id) /// @todo delete and recreate exits atomically
-			for dir, link := range room.Exits {
-				txAddExits.Exec(room.id, link, dir)
+		}
+		stmt.Close()
+		stmtExits.Close()
+		stmtExtras.Close()
+		doCommit <- tx
+	}
+	change := func(t Thing) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		txChange := tx.Stmt(changeStmt)
+		txAddExits := tx.Stmt(addExitsStmt)
+		txDelExits := tx.Stmt(delExitsStmt)
+		txAddExtras := tx.Stmt(addExtrasStmt)
+		txDelExtras := tx.Stmt(delExtrasStmt)
+		room := t.(*Room)
+
+		if _, err := txChange.Exec(room.name, room.Description, room.id); err != nil {
+			dbWriteError(err)
+		}
+		txDelExits.Exec(room.id) /// @todo delete and recreate exits atomically
+		for dir, link := range room.Exits {
+			if _, err := txAddExits.Exec(room.id, link, dir); err != nil {
+				dbWriteError(err)
 			}
-			txChange.Close()
-			txAddExits.Close()
-			txDelExits.Close()
-			doCommit <- tx
-		case id := <-saver.del:
-			tx, err := db.Begin()
-			if err != nil {
-				fmt.Println(err)
-				continue
+		}
+		if _, err := txDelExtras.Exec(room.id); err != nil {
+			dbWriteError(err)
+		}
+		for keywords, description := range room.Extras {
+			if _, err := txAddExtras.Exec(room.id, keywords, description); err != nil {
+				dbWriteError(err)
 			}
-			txDel := tx.Stmt(delStmt)
-			txDelExits := tx.Stmt(delExitsStmt)
+		}
+		txChange.Close()
+		txAddExits.Close()
+		txDelExits.Close()
+		txAddExtras.Close()
+		txDelExtras.Close()
+		doCommit <- tx
+	}
+	del := func(id identifier) {
+		tx, err := db.Begin()
+		if err != nil {
+			dbWriteError(err)
+			return
+		}
+		txDel := tx.Stmt(delStmt)
+		txDelExits := tx.Stmt(delExitsStmt)
+		txDelExtras := tx.Stmt(delExtrasStmt)
 
-			txDel.Exec(id)
-			txDelExits.Exec(id)
-			txDel.Close()
-			txDelExits.Close()
-			doCommit <- tx
+		if _, err := txDel.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		if _, err := txDelExits.Exec(id); err != nil {
+			dbWriteError(err)
 		}
+		if _, err := txDelExtras.Exec(id); err != nil {
+			dbWriteError(err)
+		}
+		txDel.Close()
+		txDelExits.Close()
+		txDelExtras.Close()
+		doCommit <- tx
 	}
+	runSaver(ThingManager(rooms).saver, add, change, del)
 }
 
 /// @todo fix loading the player's items
@@ -463,7 +832,7 @@ func tryLoadPlayer(name string, world *World) bool {
 	if world.db == nil {
 		return false
 	}
-	rows, err := world.db.Query(`select id, salt, pass, level, health, mana, room_id from players where name = '` + name + `';`)
+	rows, err := world.db.Query(`select id, account_id, level, health, mana, room_id, wrap, role, description from players where name = '` + name + `';`)
 	if err != nil {
 		fmt.Print("dberr tryLoadPlayer ")
 		fmt.Println(err)
@@ -474,21 +843,60 @@ func tryLoadPlayer(name string, world *World) bool {
 		return false
 	}
 	player := Player{
-		name:  name,
-		Items: make(map[identifier]PlayerItemType),
+		name:     name,
+		Items:    make(map[identifier]PlayerItemType),
+		Aliases:  make(map[string]string),
+		Channels: make(map[string]ChannelMembership),
+	}
+	rows.Scan(&player.id, &player.account, &player.level, &player.health, &player.mana, &player.Room, &player.Wrap, &player.role, &player.Description)
+
+	aliasRows, err := world.db.Query(`select name, expansion from player_aliases where player_id = ?;`, player.id)
+	if err != nil {
+		fmt.Print("dberr tryLoadPlayer ")
+		fmt.Println(err)
+		return false
+	}
+	for aliasRows.Next() {
+		var name, expansion string
+		aliasRows.Scan(&name, &expansion)
+		player.Aliases[name] = expansion
 	}
-	rows.Scan(&player.id, &player.passthesalt, &player.pass, &player.level, &player.health, &player.mana, &player.Room)
+	aliasRows.Close()
+
+	channelRows, err := world.db.Query(`select channel, joined, muted from player_channels where player_id = ?;`, player.id)
+	if err != nil {
+		fmt.Print("dberr tryLoadPlayer ")
+		fmt.Println(err)
+		return false
+	}
+	for channelRows.Next() {
+		var channel string
+		var membership ChannelMembership
+		channelRows.Scan(&channel, &membership.Joined, &membership.Muted)
+		player.Channels[channel] = membership
+	}
+	channelRows.Close()
 
 	ThingManager(*world.players).DbAdd(&player)
 	world.rooms.ChangeById(player.Room, func(r *Room) {
 		r.Players[player.Id()] = true
 	})
 
-	itemRows, err := world.db.Query(`select id, name, brief, location, location_type from items where location = ` + player.id.String() + `;`)
-	defer itemRows.Close()
+	itemRows, err := world.db.Query(`select `+itemColumns+` from items where location_type = ? and location = ?;`, ilPlayer, player.id)
+	if err != nil {
+		fmt.Print("dberr tryLoadPlayer ")
+		fmt.Println(err)
+		return true
+	}
+	var loaded []identifier
 	for itemRows.Next() {
-		loadItem(itemRows, world)
+		if id, ok := loadItem(itemRows, world); ok {
+			loaded = append(loaded, id)
+		}
 	}
+	itemRows.Close()
+	loadContents(world.db, world, loaded)
+	loadCarriedNpcs(world.db, world, player.id)
 
 	return true
 }
@@ -499,6 +907,7 @@ func setNextId(db *sql.DB) {
 		`npcs`,
 		`rooms`,
 		`players`,
+		`accounts`,
 	}
 	var maxid int
 	for _, table := range tables {
@@ -527,19 +936,41 @@ func setNextId(db *sql.DB) {
 
 // sqlite commits must be sequential
 var doCommit chan *sql.Tx
+var flushCommits chan chan bool
 
 func commitManager() {
+	commit := func(tx *sql.Tx) {
+		if err := tx.Commit(); err != nil {
+			dbWriteError(err)
+		}
+	}
 	for {
-		tx := <-doCommit
-		err := tx.Commit()
-		if err != nil {
-			fmt.Print("db commit err: ")
-			fmt.Println(err)
-			panic(err) // debug
+		select {
+		case tx := <-doCommit:
+			commit(tx)
+		case done := <-flushCommits:
+			for len(doCommit) > 0 {
+				commit(<-doCommit)
+			}
+			done <- true
 		}
 	}
 }
 
+// flushDb waits until every change made so far is committed to the database.
+func flushDb(world *World) {
+	if world.db == nil {
+		return
+	}
+	ThingManager(*world.rooms).Flush()
+	ThingManager(*world.items).Flush()
+	ThingManager(*world.npcs).Flush()
+	ThingManager(*world.players).Flush()
+	done := make(chan bool)
+	flushCommits <- done
+	<-done
+}
+
 func initDb(world *World) {
 	db, err := sql.Open("sqlite3", "./gomud.sqlite")
 	if err != nil {
@@ -550,10 +981,15 @@ func initDb(world *World) {
 	checkSchema(db)
 
 	doCommit = make(chan *sql.Tx, 1000)
+	flushCommits = make(chan chan bool)
 	loadRooms(db, *world.rooms)
 	loadNpcs(db, world)
 	loadItems(db, world)
+	loadSiteBans(db)
+	loadSocials(db)
+	loadChannels(db)
 	setNextId(db)
+	migrateAccounts(db)
 
 	go commitManager()
 	go roomSaver(db, *world.rooms)
@@ -0,0 +1,240 @@
+/*
+give.go contains the give command, which hands items and carried NPCs to players and NPCs in the same room.
+
+The giver, the room, the things given and the receiver are all got with World.Do, so the things move atomically.
+NPCs with a 'give' hook run it for each thing they're given; see Npc.Hook.
+*/
+package main
+
+import (
+	"fmt"
+	"github.com/Shopify/go-lua"
+	"strconv"
+	"strings"
+)
+
+// maxCarried is the most things a player or NPC can be given.
+const maxCarried = 50
+
+// splitGiveArgs splits args such as 'all.coin to bob' or 'sword bob' into the things and the receiver.
+func splitGiveArgs(args []string) (string, string, bool) {
+	if itemString, receiverString, ok := splitContainerArgs(args, "to"); ok {
+		return itemString, receiverString, true
+	}
+	if len(args) < 2 {
+		return "", "", false
+	}
+	return strings.Join(args[:len(args)-1], " "), args[len(args)-1], true
+}
+
+// roomNpcs returns the ids of the NPCs in the room, in the order they were created.
+func roomNpcs(room *Room) []identifier {
+	var ids []identifier
+	for _, id := range sortedIds(room.Items) {
+		if room.Items[id] == piNpc {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// give gives things the player is carrying to a player or NPC in the room.
+func give(args []string, playerId identifier, world *World) {
+	itemString, receiverString, ok := splitGiveArgs(args)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "Usage: give item [to] person", "give called with invalid player")
+		return
+	}
+	itemTarget, ok := ParseTarget(itemString)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "What do you want to give?", "give called with invalid player")
+		return
+	}
+	receiverTarget, ok := ParseTarget(receiverString)
+	if !ok || receiverTarget.All {
+		tryPlayerWrite(playerId, world.players, "Who do you want to give it to?", "give called with invalid player")
+		return
+	}
+
+	receiverId := invalidIdentifier
+	receiverIsNpc := false
+	getPlayer := func(got Got) (*ToGet, error) {
+		return PlayerGet(playerId), nil
+	}
+	getRoom := func(got Got) (*ToGet, error) {
+		player, ok := got.players[playerId]
+		if !ok {
+			return nil, fmt.Errorf("Error giving for player %v: not returned from manager!", playerId)
+		}
+		return RoomGet(player.Room), nil
+	}
+	getThings := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room, ok := got.rooms[player.Room]
+		if !ok {
+			return nil, fmt.Errorf("Error giving for player %v: room %v not returned from manager!", playerId, player.Room)
+		}
+		toGet := &ToGet{}
+		for _, things := range tsInventoryAndRoom.things(player, room) {
+			for id, itemType := range things {
+				switch itemType {
+				case piItem:
+					toGet.items = append(toGet.items, id)
+				case piNpc:
+					toGet.npcs = append(toGet.npcs, id)
+				}
+			}
+		}
+		return toGet, nil
+	}
+	getReceiver := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room := got.rooms[player.Room]
+		if npcs := resolveAmong(receiverTarget, roomNpcs(room), got); len(npcs) == 1 {
+			receiverId = npcs[0]
+			receiverIsNpc = true
+			return nil, nil
+		}
+		other, ok := findPlayerInRoom(receiverTarget, player, room, world)
+		if !ok {
+			player.Write("You don't see them here.")
+			return nil, nil
+		}
+		if other.Id() == player.Id() {
+			player.Write("You can't give things to yourself.")
+			return nil, nil
+		}
+		receiverId = other.Id()
+		return PlayerGet(receiverId), nil
+	}
+	run := func(got Got) (*ToGet, error) {
+		if receiverId == invalidIdentifier {
+			return nil, nil
+		}
+		player := got.players[playerId]
+		room := got.rooms[player.Room]
+		if receiverIsNpc {
+			giveToNpc(player, room, got.npcs[receiverId], itemTarget, got, world)
+			return nil, nil
+		}
+		receiver, ok := got.players[receiverId]
+		if !ok || receiver.Room != room.Id() {
+			player.Write("You don't see them here.")
+			return nil, nil
+		}
+		giveToPlayer(player, room, receiver, itemTarget, got, world)
+		return nil, nil
+	}
+	if err := world.Do([]DoFunc{getPlayer, getRoom, getThings, getReceiver, run}); err != nil {
+		fmt.Printf("give error: %v\n", err)
+	}
+}
+
+// givenThing moves the thing from the player's inventory, and returns its brief,
+// and whether it's something which can be given.
+func givenThing(player *Player, id identifier, location identifier, locationType ItemLocationType, got Got) (string, bool) {
+	if item, ok := got.items[id]; ok {
+		item.Location = location
+		item.LocationType = locationType
+		delete(player.Items, id)
+		return item.Brief(), true
+	}
+	if npc, ok := got.npcs[id]; ok {
+		npc.Location = location
+		npc.LocationType = locationType
+		delete(player.Items, id)
+		return npc.Brief, true
+	}
+	return "", false
+}
+
+func giveToPlayer(player *Player, room *Room, receiver *Player, itemTarget Target, got Got, world *World) {
+	targets := ResolveTarget(itemTarget, tsInventory, player, room, got)
+	if len(targets) == 0 {
+		player.Write(tsInventory.NotFound())
+		return
+	}
+	for _, id := range targets {
+		if len(receiver.Items) >= maxCarried {
+			player.Write(ToProper(receiver.Name()) + " can't carry any more.")
+			return
+		}
+		itemType := player.Items[id]
+		brief, ok := givenThing(player, id, receiver.Id(), ilPlayer, got)
+		if !ok {
+			continue
+		}
+		receiver.Items[id] = itemType
+		player.Write("You give " + brief + " to " + ToProper(receiver.Name()) + ".")
+		receiver.Write(ToProper(player.Name()) + " gives you " + brief + ".")
+		room.Write(ToProper(player.Name())+" gives "+brief+" to "+ToProper(receiver.Name())+".", *world.players, player.Name(), receiver.Name())
+	}
+}
+
+func giveToNpc(player *Player, room *Room, receiver *Npc, itemTarget Target, got Got, world *World) {
+	targets := ResolveTarget(itemTarget, tsInventory, player, room, got)
+	if len(targets) == 0 {
+		player.Write(tsInventory.NotFound())
+		return
+	}
+	giverId, giverName := player.Id(), player.Name() // the hooks run after the player is released
+	for _, id := range targets {
+		if len(receiver.Items) >= maxCarried {
+			player.Write(ToProper(receiver.Brief) + " can't carry any more.")
+			return
+		}
+		itemType := player.Items[id]
+		name := ""
+		if item, ok := got.items[id]; ok {
+			name = item.Name()
+		} else if npc, ok := got.npcs[id]; ok {
+			name = npc.Name()
+		}
+		brief, ok := givenThing(player, id, receiver.Id(), ilNpc, got)
+		if !ok {
+			continue
+		}
+		receiver.Items[id] = itemType == piNpc
+		player.Write("You give " + brief + " to " + receiver.Brief + ".")
+		id := id // the hook runs after the loop moves on
+		room.Write(ToProper(player.Name())+" gives "+brief+" to "+receiver.Brief+".", *world.players, player.Name())
+		receiver.Hook(world, npcHookGive, map[string]func(l *lua.State){
+			"giver": func(l *lua.State) { luaPushPlayer(l, giverId, giverName) },
+			"item":  func(l *lua.State) { luaPushItem(l, id, name, brief) },
+		})
+	}
+}
+
+// hookNpc sets the script an NPC runs when something happens to it, e.g. 'hooknpc 42 give gomud_say("Thanks!")'.
+// With no script, the hook is removed.
+func hookNpc(args []string, playerId identifier, world *World) {
+	npcInt, err := strconv.Atoi(args[0])
+	if err != nil {
+		tryPlayerWrite(playerId, world.players, "Please provide a valid identifier to hook.", "hookNpc called with invalid id")
+		return
+	}
+	event := strings.ToLower(args[1])
+	valid := false
+	for _, hook := range npcHooks {
+		valid = valid || hook == event
+	}
+	if !valid {
+		tryPlayerWrite(playerId, world.players, "NPCs can have hooks for: "+strings.Join(npcHooks, ", "), "hookNpc called with invalid event")
+		return
+	}
+	script := strings.Join(args[2:], " ")
+	if !world.npcs.ChangeById(identifier(npcInt), func(n *Npc) {
+		if n.Hooks == nil {
+			n.Hooks = make(map[string]string)
+		}
+		if script == "" {
+			delete(n.Hooks, event)
+			tryPlayerWrite(playerId, world.players, "The "+n.Name()+" forgets what to do on "+event+".", "hookNpc succeeded but player disappeared")
+			return
+		}
+		n.Hooks[event] = script
+		tryPlayerWrite(playerId, world.players, "The "+n.Name()+" will know what to do on "+event+".", "hookNpc succeeded but player disappeared")
+	}) {
+		tryPlayerWrite(playerId, world.players, "There is no NPC with that identifier.", "hookNpc called with invalid id")
+	}
+}
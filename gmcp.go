@@ -0,0 +1,220 @@
+/*
+gmcp.go implements the Generic Mud Communication Protocol (GMCP, telnet option 201).
+
+GMCP messages are subnegotiations of the form IAC SB GMCP "Package.Name json" IAC SE.
+
+Clients tell us who they are with Core.Hello, and which packages they want with Core.Supports.
+We push Char.Vitals, Room.Info and Char.Items.List whenever the Things they describe change,
+so clients can draw gauges, maps and inventories without parsing text.
+
+Changes are pushed by publishChanges, in oob.go.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const optGMCP = 201
+
+// gmcpState is what the client has told us about itself over GMCP.
+type gmcpState struct {
+	client   string
+	version  string
+	supports map[string]int // module to version; nil if the client never sent Core.Supports
+}
+
+// handleGMCP handles a GMCP message from the client.
+func (t *TelnetConn) handleGMCP(option byte, data []byte) {
+	pkg := string(data)
+	var message []byte
+	if space := bytes.IndexByte(data, ' '); space >= 0 {
+		pkg = string(data[:space])
+		message = data[space+1:]
+	}
+
+	switch strings.ToLower(pkg) {
+	case "core.hello":
+		hello := struct {
+			Client  string `json:"client"`
+			Version string `json:"version"`
+		}{}
+		if err := json.Unmarshal(message, &hello); err != nil {
+			fmt.Println("gmcp Core.Hello error: " + err.Error())
+			return
+		}
+		t.optionsMutex.Lock()
+		t.gmcp.client = hello.Client
+		t.gmcp.version = hello.Version
+		t.optionsMutex.Unlock()
+	case "core.supports.set":
+		t.gmcpSupports(message, true, true)
+	case "core.supports.add":
+		t.gmcpSupports(message, false, true)
+	case "core.supports.remove":
+		t.gmcpSupports(message, false, false)
+	case "core.ping":
+		t.SendGMCP("Core.Ping", nil)
+	}
+}
+
+// gmcpSupports changes the modules the client supports. Modules are strings like "Char 1".
+// If set, the existing modules are replaced. If add is false, the modules are removed.
+func (t *TelnetConn) gmcpSupports(message []byte, set bool, add bool) {
+	var modules []string
+	if err := json.Unmarshal(message, &modules); err != nil {
+		fmt.Println("gmcp Core.Supports error: " + err.Error())
+		return
+	}
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	if set || t.gmcp.supports == nil {
+		t.gmcp.supports = make(map[string]int)
+	}
+	for _, module := range modules {
+		fields := strings.Fields(module)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if !add {
+			delete(t.gmcp.supports, name)
+			continue
+		}
+		version := 1
+		if len(fields) > 1 {
+			if v, err := strconv.Atoi(fields[1]); err == nil {
+				version = v
+			}
+		}
+		t.gmcp.supports[name] = version
+	}
+}
+
+// GMCPClient returns the client name and version sent in Core.Hello, if any.
+func (t *TelnetConn) GMCPClient() (string, string) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.gmcp.client, t.gmcp.version
+}
+
+// gmcpSupported returns whether the client wants the package, e.g. "Char.Vitals" is wanted if the client supports "Char".
+// Clients which never sent Core.Supports get everything.
+func (t *TelnetConn) gmcpSupported(pkg string) bool {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	if t.gmcp.supports == nil {
+		return true
+	}
+	module := strings.ToLower(pkg)
+	for {
+		if module == "core" {
+			return true
+		}
+		if _, ok := t.gmcp.supports[module]; ok {
+			return true
+		}
+		dot := strings.LastIndex(module, ".")
+		if dot < 0 {
+			return false
+		}
+		module = module[:dot]
+	}
+}
+
+// SendGMCP sends the message to the client as json, if the client has GMCP on and supports the package.
+// A nil message sends the package name alone.
+func (t *TelnetConn) SendGMCP(pkg string, message interface{}) {
+	if !t.Local(optGMCP) || !t.gmcpSupported(pkg) {
+		return
+	}
+	data := []byte(pkg)
+	if message != nil {
+		j, err := json.Marshal(message)
+		if err != nil {
+			fmt.Println("SendGMCP error: " + pkg + " " + err.Error())
+			return
+		}
+		data = append(data, ' ')
+		data = append(data, j...)
+	}
+	t.Subnegotiate(optGMCP, data)
+}
+
+// gmcpSent is the last message of each package sent to a player's connection, so unchanged messages aren't resent.
+type gmcpSent struct {
+	conn     *TelnetConn
+	messages map[string]string
+}
+
+// sendIfChanged sends the GMCP message to the player, if it differs from the last one sent for the package.
+func sendIfChanged(player *Player, sent map[identifier]*gmcpSent, pkg string, message interface{}) {
+	t, ok := telnetOf(player.connection)
+	if !ok || !t.Local(optGMCP) {
+		return
+	}
+	playerSent, ok := sent[player.Id()]
+	if !ok || playerSent.conn != t {
+		playerSent = &gmcpSent{conn: t, messages: make(map[string]string)}
+		sent[player.Id()] = playerSent
+	}
+	j, err := json.Marshal(message)
+	if err != nil {
+		fmt.Println("gmcp sendIfChanged error: " + pkg + " " + err.Error())
+		return
+	}
+	if playerSent.messages[pkg] == string(j) {
+		return
+	}
+	playerSent.messages[pkg] = string(j)
+	t.SendGMCP(pkg, message)
+}
+
+func gmcpVitals(player *Player) interface{} {
+	return map[string]uint{
+		"hp":    player.health,
+		"maxhp": player.MaxHealth(),
+		"mp":    player.mana,
+		"maxmp": player.MaxMana(),
+	}
+}
+
+func gmcpRoomInfo(room *Room) interface{} {
+	exits := map[string]identifier{}
+	for direction, id := range room.Exits {
+		exits[direction.String()] = id
+	}
+	return map[string]interface{}{
+		"num":   room.Id(),
+		"name":  room.Name(),
+		"exits": exits,
+	}
+}
+
+func gmcpItems(player *Player, world *World) interface{} {
+	type gmcpItem struct {
+		Id   identifier `json:"id"`
+		Name string     `json:"name"`
+	}
+	items := []gmcpItem{}
+	for id, itemType := range player.Items {
+		switch itemType {
+		case piItem:
+			if item, ok := world.items.GetById(id); ok {
+				items = append(items, gmcpItem{id, item.Brief()})
+			}
+		case piNpc:
+			if npc, ok := world.npcs.GetById(id); ok {
+				items = append(items, gmcpItem{id, npc.Brief})
+			}
+		}
+	}
+	return map[string]interface{}{
+		"location": "inv",
+		"items":    items,
+	}
+}
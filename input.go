@@ -0,0 +1,117 @@
+/*
+input.go limits what clients can send, so a single client can't tie up goroutines or memory.
+
+Clients which don't log in within the login timeout, and players idle for longer than the idle timeout, are disconnected.
+Lines longer than the maximum line length are discarded.
+Players' commands are rate limited with a token bucket. Commands which exceed the rate are delayed if
+a token is coming soon, and rejected with a warning if not.
+*/
+package main
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	defaultLoginTimeout  = 2 * time.Minute
+	defaultIdleTimeout   = 30 * time.Minute
+	defaultMaxLineLength = 1024
+	defaultCommandRate   = 5.0
+	defaultCommandBurst  = 10
+)
+
+// maxCommandDelay is the longest a command is delayed by the rate limit. Commands which would wait longer are rejected.
+const maxCommandDelay = time.Second
+
+// tokenBucket allows burst events at once, refilled at rate events per second. A rate of 0 allows everything.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	return tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, and returns how long to wait before using it, or false if that's longer than max.
+func (b *tokenBucket) wait(now time.Time, max time.Duration) (time.Duration, bool) {
+	if b.rate <= 0 {
+		return 0, true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > max {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// Read reads from the client, disconnecting them if they're idle for too long.
+func (s *Session) Read(p []byte) (int, error) {
+	timeout := s.idleTimeout
+	message := "You have been idle too long. Goodbye.\r\n"
+	if s.Player() == invalidIdentifier {
+		timeout = s.loginTimeout
+		message = "\r\nYou took too long to log in. Goodbye.\r\n"
+	}
+	if timeout > 0 {
+		s.Conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		s.Conn.SetReadDeadline(time.Time{})
+	}
+	n, err := s.Conn.Read(p)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		s.Write([]byte(message))
+		s.Close()
+	}
+	return n, err
+}
+
+// Throttle applies the command rate limit. It returns false if the command should be rejected.
+// It may sleep, so it must only be called by the session's reader.
+func (s *Session) Throttle() bool {
+	wait, ok := s.commands.wait(time.Now(), maxCommandDelay)
+	if !ok {
+		s.Write([]byte("\r\nYou are sending commands too quickly. That command was ignored.\r\n"))
+		s.throttled = true
+		return false
+	}
+	if wait > 0 {
+		if !s.throttled {
+			s.Write([]byte("\r\nYou are sending commands too quickly. Slow down.\r\n"))
+			s.throttled = true
+		}
+		time.Sleep(wait)
+		return true
+	}
+	s.throttled = false
+	return true
+}
+
+// maxLineLength returns the longest line the connection may send.
+func maxLineLength(c net.Conn) int {
+	if s, ok := sessionOf(c); ok && s.maxLine > 0 {
+		return s.maxLine
+	}
+	return defaultMaxLineLength
+}
+
+// throttle applies the connection's command rate limit, if it's a Session. It returns false if the command should be rejected.
+func throttle(c net.Conn) bool {
+	if s, ok := sessionOf(c); ok {
+		return s.Throttle()
+	}
+	return true
+}
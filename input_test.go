@@ -0,0 +1,50 @@
+/*
+input_test.go tests the command rate limit's token bucket.
+*/
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(2, 3)
+	b.last = start
+	steps := []struct {
+		name string
+		at   time.Duration
+		wait time.Duration
+		ok   bool
+	}{
+		{"burst 1", 0, 0, true},
+		{"burst 2", 0, 0, true},
+		{"burst 3", 0, 0, true},
+		{"next token soon", 0, 500 * time.Millisecond, true},
+		{"next token at the limit", 0, time.Second, true},
+		{"next token too far off", 0, 0, false},
+		{"rejected commands take no token", 0, 0, false},
+		{"refilled by the rate", time.Second, 500 * time.Millisecond, true},
+		{"refill capped at the burst 1", time.Minute, 0, true},
+		{"refill capped at the burst 2", time.Minute, 0, true},
+		{"refill capped at the burst 3", time.Minute, 0, true},
+		{"refill capped at the burst 4", time.Minute, 500 * time.Millisecond, true},
+	}
+	for _, step := range steps {
+		wait, ok := b.wait(start.Add(step.at), maxCommandDelay)
+		if wait != step.wait || ok != step.ok {
+			t.Errorf("%s: wait returned %v %v, want %v %v", step.name, wait, ok, step.wait, step.ok)
+		}
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	b := newTokenBucket(0, 1)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if wait, ok := b.wait(now, maxCommandDelay); wait != 0 || !ok {
+			t.Fatalf("command %d: wait returned %v %v, want 0 true", i, wait, ok)
+		}
+	}
+}
@@ -11,6 +11,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
 type ItemLocationType int32
@@ -19,21 +20,38 @@ const (
 	ilRoom = iota
 	ilPlayer
 	ilNpc
+	ilItem
 )
 
 type Item struct {
 	id           identifier
 	name         string
 	brief        string
+	keywords     string // words players can refer to the item by, besides its name
+	description  string // what players see when they look at the item
 	Location     identifier
 	LocationType ItemLocationType ///< @todo ? remove this ? it isn't strictly necessary, as we can type assert to find the type
 	Items        map[identifier]bool
+	Capacity     int // how many items the item can hold. 0 if it isn't a container. See containers.go
+	Closed       bool
+	Locked       bool
+	Key          identifier // the item which locks and unlocks the container, or invalidIdentifier if it has no lock
 }
 
 func (i *Item) Id() identifier {
 	return i.id
 }
 
+// Snapshot returns a copy of the item which later changes to it don't touch, for publishing. See Snapshotter.
+func (i *Item) Snapshot() Thing {
+	s := *i
+	s.Items = make(map[identifier]bool, len(i.Items))
+	for id, isNpc := range i.Items {
+		s.Items[id] = isNpc
+	}
+	return &s
+}
+
 func (i *Item) SetId(newId identifier) {
 	i.id = newId
 }
@@ -46,6 +64,15 @@ func (i *Item) Brief() string {
 	return i.brief
 }
 
+func (i *Item) Description() string {
+	return i.description
+}
+
+// Keywords returns the words players can refer to the item by, including its name.
+func (i *Item) Keywords() []string {
+	return strings.Fields(strings.ToLower(i.name + " " + i.keywords))
+}
+
 type ItemManager ThingManager
 
 /// @todo remove this, after changing things which call it to store Accessors rather than IDs
@@ -0,0 +1,150 @@
+/*
+linkdead.go handles players whose connections close, and players who reconnect.
+
+When a player's connection closes without them quitting, the player goes link-dead.
+They stay in the world, and are shown as link-dead in rooms, until they reconnect,
+or the link-dead timeout passes, when they're unloaded from the world.
+
+When a player logs in while they're already in the world, link-dead or not,
+the new connection takes over, and the old connection is closed.
+*/
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const defaultLinkDeadTimeout = 10 * time.Minute
+
+// disconnectPlayer is called when the player's connection c closes.
+// If c is no longer the player's connection, it was taken over, and nothing happens.
+func disconnectPlayer(world *World, playerId identifier, c net.Conn) {
+	taken := false
+	quitting := false
+	var since time.Time
+	ok := world.players.ChangeById(playerId, func(p *Player) {
+		if p.connection != c {
+			taken = true
+			return
+		}
+		p.connection = nil
+		if p.state == psQuitting {
+			quitting = true
+			return
+		}
+		p.state = psLinkDead
+		p.linkDead = time.Now()
+		since = p.linkDead
+	})
+	c.Close()
+	if !ok || taken {
+		return
+	}
+	if quitting {
+		unloadPlayer(world, playerId, " has left the game.")
+		return
+	}
+
+	if player, ok := world.players.GetById(playerId); ok {
+		if room, ok := world.rooms.GetById(player.Room); ok {
+			room.Write(ToProper(player.Name())+" has lost their link.", *world.players, player.Name())
+		}
+	}
+	if world.config.LinkDeadTimeout > 0 {
+		time.AfterFunc(world.config.LinkDeadTimeout, func() {
+			reapLinkDead(world, playerId, since)
+		})
+	}
+}
+
+// reapLinkDead unloads the player, if they're still link-dead since the given time.
+func reapLinkDead(world *World, playerId identifier, since time.Time) {
+	worldPause.RLock()
+	defer worldPause.RUnlock()
+	player, ok := world.players.GetById(playerId)
+	if !ok || !player.LinkDead() || !player.linkDead.Equal(since) {
+		return
+	}
+	unloadPlayer(world, playerId, " fades out of existence.")
+}
+
+// unloadPlayer removes the player and the items and NPCs they carry from the world, without deleting them from the database.
+// They're loaded again when the player next logs in.
+func unloadPlayer(world *World, playerId identifier, roomMessage string) {
+	player, ok := world.players.GetById(playerId)
+	if !ok {
+		return
+	}
+	world.rooms.ChangeById(player.Room, func(r *Room) {
+		delete(r.Players, playerId)
+	})
+	if room, ok := world.rooms.GetById(player.Room); ok {
+		room.Write(ToProper(player.Name())+roomMessage, *world.players, player.Name())
+	}
+	for id, itemType := range player.Items {
+		switch itemType {
+		case piItem:
+			unloadItem(world, id)
+		case piNpc:
+			unloadNpc(world, id)
+		}
+	}
+	ThingManager(*world.players).Unload(playerId)
+	fmt.Println("unloaded player " + player.Name())
+}
+
+// unloadItem removes the item, and the items in it if it's a container, and so on, from the world,
+// without deleting them from the database. They're loaded again with their container; see loadContents.
+func unloadItem(world *World, itemId identifier) {
+	if item, ok := world.items.GetById(itemId); ok {
+		for _, id := range containedIds(item) {
+			unloadItem(world, id)
+		}
+	}
+	ThingManager(*world.items).Unload(itemId)
+}
+
+// unloadNpc removes the NPC and the things it carries from the world, without deleting them from the database.
+func unloadNpc(world *World, npcId identifier) {
+	npc, ok := world.npcs.GetById(npcId)
+	if !ok {
+		return
+	}
+	for id, isNpc := range npc.Items {
+		if isNpc {
+			unloadNpc(world, id)
+		} else {
+			unloadItem(world, id)
+		}
+	}
+	ThingManager(*world.npcs).Unload(npcId)
+}
+
+// takeOverPlayer makes c the player's connection, and closes their old connection, if any.
+func takeOverPlayer(world *World, playerId identifier, c net.Conn) bool {
+	var old net.Conn
+	wasLinkDead := false
+	ok := world.players.ChangeById(playerId, func(p *Player) {
+		old = p.connection
+		wasLinkDead = p.LinkDead()
+		p.connection = c
+		p.state = psPlaying
+	})
+	if !ok {
+		return false
+	}
+	if old != nil {
+		old.Write([]byte("\r\nThis character has been taken over by a new connection.\r\n"))
+		old.Close() // the old reader sees the connection change, and stops
+	}
+	if old != nil || wasLinkDead {
+		if player, ok := world.players.GetById(playerId); ok {
+			if room, ok := world.rooms.GetById(player.Room); ok {
+				room.Write(ToProper(player.Name())+" has reconnected.", *world.players, player.Name())
+			}
+		}
+	}
+	return true
+}
@@ -7,168 +7,295 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/scrypt"
 	"io"
 	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func handleCreatingPlayerPassVerify(world World, c net.Conn, playerName string, newPass []byte) {
-	defer func() {
-		for i := range newPass {
-			newPass[i] = 0
-		}
-	}()
-	playerName = strings.ToLower(playerName)
-	c.Write([]byte("Please verify your password.\r\n"))
-	passVerify, err := getBytesSecure(c)
-	defer func() {
-		for i := range passVerify {
-			passVerify[i] = 0
-		}
-	}()
+func handleCreatingAccount(world World, c net.Conn, login string) {
+	const accountCreateMessage = "No account by that name exists. Do you want to create an account?"
+	c.Write([]byte(accountCreateMessage + "\r\n"))
+	createReply, err := getString(c)
 	if err != nil {
 		return
 	}
-	if bytes.Compare(newPass, passVerify) != 0 {
-		c.Write([]byte("The passwords you entered do not match.\r\n"))
-		go handleCreatingPlayerPass(world, c, playerName)
+	if !strings.HasPrefix(strings.ToLower(createReply), "y") {
+		go handleLogin(world, c)
 		return
 	}
-	fmt.Println("creating player")
-	salt := make([]byte, 128)
-	n, err := rand.Read(salt)
-	if n != len(salt) || err != nil {
-		fmt.Println("Error creating salt.")
-		c.Close()
+	for {
+		pass, ok, err := readNewPassword(c)
+		if err != nil {
+			return
+		}
+		if !ok {
+			c.Write([]byte("The passwords you entered do not match.\r\n"))
+			continue
+		}
+		fmt.Println("creating account")
+		account, created, err := createAccount(&world, login, pass)
+		zero(pass)
+		if err != nil {
+			fmt.Println("Error creating account: " + err.Error())
+			c.Close()
+			return
+		}
+		if !created {
+			c.Write([]byte("That account name was just taken. Please choose another.\r\n"))
+			go handleLogin(world, c)
+			return
+		}
+		go handleCreatingCharacter(world, c, account)
 		return
 	}
+}
 
-	hashedPass, err := scrypt.Key([]byte(newPass), salt, 16384, 8, 1, 32)
-	if err != nil {
-		fmt.Println("Error creating salt.")
+func handleLoginPass(world World, c net.Conn, login string) {
+	ip := remoteIP(c)
+	if wait, locked := loginLockedOut(ip); locked {
+		c.Write([]byte("Too many failed logins. Please try again in " + strconv.Itoa(int(wait.Seconds())+1) + " seconds.\r\n"))
 		c.Close()
 		return
 	}
-
-	roomId := identifier(0)
-	newPlayer := Player{
-		name:        playerName,
-		pass:        hashedPass,
-		passthesalt: salt,
-		connection:  c,
-		level:       1,
-		Room:        roomId,
-		Items:       make(map[identifier]PlayerItemType),
-	}
-	newPlayerId := ThingManager(*world.players).Add(&newPlayer)
-	world.rooms.ChangeById(roomId, func(r *Room) {
-		r.Players[newPlayerId] = true
-	})
-	//	fmt.Println("Debug: " + playerName + " id: " + newPlayerId.String())
-	_, exists := world.players.GetByName(playerName)
+	account, exists := loadAccount(world.db, login)
 	if !exists {
-		fmt.Println("handleCreatingPlayerPassVerify error: newly created player does not exist: " + playerName)
-		c.Close()
+		c.Write([]byte("Please try again.\r\n"))
+		go handleLogin(world, c)
 		return
 	}
-	world.players.ChangeById(newPlayerId, func(p *Player) {
-		p.health = p.MaxHealth()
-		p.mana = p.MaxMana()
-	})
-	go handlePlayer(world, newPlayerId)
+
+	if account.resetToken != "" {
+		if !handleResetLogin(world, c, account) {
+			return
+		}
+	} else {
+		pass, err := readPassword(c, "Please enter your password.")
+		defer zero(pass)
+		if err != nil {
+			return
+		}
+		ok, err := checkPassword(account, pass)
+		if err != nil {
+			fmt.Printf("Error creating hashed pass: %v\n", err)
+			c.Close()
+			return
+		}
+		if !ok {
+			loginFailed(ip)
+			c.Write([]byte("Invalid password.\r\n"))
+			c.Close()
+			return
+		}
+		loginSucceeded(ip)
+		rehashIfOutdated(&world, account, pass)
+	}
+	account.LastLogin = time.Now()
+	saveAccount(world.db, account)
+	go handleCharacterMenu(world, c, account)
 }
 
-func handleCreatingPlayerPass(world World, c net.Conn, player string) {
-	c.Write([]byte("Please enter a password for your character.\r\n"))
-	pass, err := getBytesSecure(c)
-	if err != nil {
+// handleCharacterMenu lets a logged-in account choose a character to play, or create a new one
+func handleCharacterMenu(world World, c net.Conn, account *Account) {
+	characters := accountCharacters(&world, account)
+	if len(characters) == 0 {
+		c.Write([]byte("Your account has no characters.\r\n"))
+		go handleCreatingCharacter(world, c, account)
 		return
 	}
-	go handleCreatingPlayerPassVerify(world, c, player, pass)
+	last := account.Settings[settingLastCharacter]
+	for {
+		menu := "Your characters:\r\n"
+		for i, name := range characters {
+			menu += "  " + strconv.Itoa(i+1) + ". " + ToProper(name)
+			if name == last {
+				menu += " (last played)"
+			}
+			menu += "\r\n"
+		}
+		menu += "  N. Create a new character\r\n"
+		menu += "  Q. Quit\r\n"
+		menu += "Please choose a character:\r\n"
+		c.Write([]byte(menu))
+
+		choice, err := getString(c)
+		if err != nil {
+			return
+		}
+		choice = strings.ToLower(choice)
+		switch choice {
+		case "n", "new":
+			go handleCreatingCharacter(world, c, account)
+			return
+		case "q", "quit":
+			c.Write([]byte("Goodbye.\r\n"))
+			c.Close()
+			return
+		case "":
+			if last == "" {
+				continue
+			}
+			choice = last
+		}
+		if i, err := strconv.Atoi(choice); err == nil && i >= 1 && i <= len(characters) {
+			choice = characters[i-1]
+		}
+		for _, name := range characters {
+			if name == choice {
+				go handleSelectingCharacter(world, c, account, name)
+				return
+			}
+		}
+		c.Write([]byte("That is not one of your characters.\r\n"))
+	}
 }
 
-func handleCreatingPlayer(world World, c net.Conn, player string) {
-	const playerCreateMessage = "No player by that name exists. Do you want to create a player?"
-	c.Write([]byte(playerCreateMessage + "\r\n"))
-	createReply, err := getString(c)
-	if err != nil {
+// handleSelectingCharacter puts the account's chosen character into the world, or reconnects them if they're already in it
+func handleSelectingCharacter(world World, c net.Conn, account *Account, playerName string) {
+	worldPause.RLock()
+	defer worldPause.RUnlock()
+	player, exists := world.players.GetByName(playerName)
+	if !exists && tryLoadPlayer(playerName, &world) {
+		player, exists = world.players.GetByName(playerName)
+	}
+	if !exists || player.account != account.Id() {
+		c.Write([]byte("That character could not be loaded. Please try again.\r\n"))
+		go handleCharacterMenu(world, c, account)
 		return
 	}
-	if strings.HasPrefix(createReply, "y") {
-		go handleCreatingPlayerPass(world, c, player)
+	if !takeOverPlayer(&world, player.Id(), c) {
+		c.Write([]byte("Please try again.\r\n"))
+		go handleCharacterMenu(world, c, account)
 		return
 	}
-	go handleLogin(world, c)
+	if world.config.Owner == playerName && world.owner == account.Id() && player.Role() != roleOwner {
+		world.players.ChangeById(player.Id(), func(p *Player) {
+			p.role = roleOwner
+		})
+		c.Write([]byte("You are now the owner of this world.\r\n"))
+	}
+	account.Settings[settingLastCharacter] = playerName
+	saveAccount(world.db, account)
+	go handlePlayer(world, player.Id())
 }
 
-func handleLoginPass(world World, c net.Conn, playerName string) {
-	playerName = strings.ToLower(playerName)
-	c.Write([]byte("Please enter your password.\r\n"))
-	pass, err := getBytesSecure(c)
-	defer func() {
-		for i := range pass {
-			pass[i] = 0
-		}
-	}()
-	if err != nil {
-		return
-	}
-	player, exists := world.players.GetByName(playerName)
-	if !exists {
-		return // we just validated the player exists, so this shouldn't happen
+// handleCreatingCharacter creates a new character for the account, and puts them into the world
+func handleCreatingCharacter(world World, c net.Conn, account *Account) {
+	c.Write([]byte("Please enter a name for your new character, or nothing to go back.\r\n"))
+	var playerName string
+	for {
+		name, err := getString(c)
+		if err != nil {
+			return
+		}
+		if name == "" {
+			go handleCharacterMenu(world, c, account)
+			return
+		}
+		name = strings.ToLower(name)
+		const validNameRegex = "^[a-zA-Z]+$" // names can only contain letters
+		valid, err := regexp.MatchString(validNameRegex, name)
+		if err != nil || !valid {
+			c.Write([]byte("That is not a valid name. Please enter a name for your character.\r\n"))
+			continue
+		}
+		if characterExists(&world, name) {
+			c.Write([]byte("That name is taken. Please enter another name for your character.\r\n"))
+			continue
+		}
+		playerName = name
+		break
 	}
 
-	hashedPass, err := scrypt.Key([]byte(pass), player.passthesalt, 16384, 8, 1, 32)
-	if err != nil {
-		fmt.Printf("Error creating hashed pass: %v\n", err)
-		c.Close()
+	worldPause.RLock()
+	defer worldPause.RUnlock()
+	characterCreation.Lock()
+	if characterExists(&world, playerName) {
+		characterCreation.Unlock()
+		c.Write([]byte("That name was just taken.\r\n"))
+		go handleCreatingCharacter(world, c, account)
 		return
 	}
-
-	if !bytes.Equal(hashedPass, player.pass) {
-		c.Write([]byte("Invalid password.\r\n"))
+	fmt.Println("creating player")
+	role := rolePlayer
+	if noPlayersExist(&world) {
+		role = roleOwner
+	}
+	roomId := identifier(0)
+	newPlayer := Player{
+		name:       playerName,
+		account:    account.Id(),
+		connection: c,
+		level:      1,
+		role:       role,
+		Room:       roomId,
+		Items:      make(map[identifier]PlayerItemType),
+		Aliases:    make(map[string]string),
+		Channels:   make(map[string]ChannelMembership),
+	}
+	newPlayerId := ThingManager(*world.players).Add(&newPlayer)
+	characterCreation.Unlock()
+	// wait for the character to be committed, so the account's character menu, which reads the database, lists it
+	ThingManager(*world.players).Flush()
+	done := make(chan bool)
+	flushCommits <- done
+	<-done
+	world.rooms.ChangeById(roomId, func(r *Room) {
+		r.Players[newPlayerId] = true
+	})
+	//	fmt.Println("Debug: " + playerName + " id: " + newPlayerId.String())
+	_, exists := world.players.GetByName(playerName)
+	if !exists {
+		fmt.Println("handleCreatingCharacter error: newly created player does not exist: " + playerName)
 		c.Close()
 		return
 	}
-	world.players.ChangeById(player.Id(), func(p *Player) {
-		p.connection = c
+	world.players.ChangeById(newPlayerId, func(p *Player) {
+		p.health = p.MaxHealth()
+		p.mana = p.MaxMana()
 	})
-	go handlePlayer(world, player.Id())
+	if role == roleOwner {
+		c.Write([]byte("You are the first player, and the owner of this world.\r\n"))
+	}
+	replayChannels(newPlayerId, &world)
+	account.Settings[settingLastCharacter] = playerName
+	saveAccount(world.db, account)
+	go handlePlayer(world, newPlayerId)
 }
 
 // this handles new connections, which are not yet logged in
 func handleLogin(world World, c net.Conn) {
-	negotiateTelnet(c)
-	const loginMessageString = "Please enter your name:\r\n"
+	if world.db == nil {
+		c.Write([]byte("Logins are unavailable. Please try again later.\r\n"))
+		c.Close()
+		return
+	}
+	const loginMessageString = "Please enter your account name:\r\n"
 	c.Write([]byte(loginMessageString))
 	for {
-		player, error := getString(c)
+		login, error := getString(c)
 		if error != nil {
 			return
 		}
-		player = strings.ToLower(player)
+		login = strings.ToLower(login)
 		const validNameRegex = "^[a-zA-Z]+$" // names can only contain letters
-		valid, err := regexp.MatchString(validNameRegex, player)
+		valid, err := regexp.MatchString(validNameRegex, login)
 		if err != nil || !valid {
-			const invalidNameMessage = "That is not a valid name. Please enter your name."
+			const invalidNameMessage = "That is not a valid name. Please enter your account name."
 			c.Write([]byte(invalidNameMessage + "\r\n"))
 			continue
 		}
 
-		// if the current player doesn't exist, assume the sent text is the player name
-		_, playerExists := world.players.GetByName(player)
-		if !playerExists && !tryLoadPlayer(player, &world) {
-			handleCreatingPlayer(world, c, player)
+		if _, accountExists := loadAccount(world.db, login); !accountExists {
+			handleCreatingAccount(world, c, login)
 			return
 		}
-		go handleLoginPass(world, c, player)
+		go handleLoginPass(world, c, login)
 		break
 	}
 }
@@ -180,37 +307,37 @@ func handlePlayer(world World, playerId identifier) {
 		fmt.Println("handlePlayer error: player not found " + playerId.String())
 		return
 	}
+	c := player.connection
+	if s, ok := sessionOf(c); ok {
+		s.SetPlayer(playerId)
+	}
 	player.Write("Welcome " + ToProper(player.Name()) + "!")
 	look([]string{}, playerId, &world)
+	bindMSDP(c, playerId, &world)
+	// publishes the player's state and room to out-of-band clients
+	world.rooms.ChangeById(player.Room, func(r *Room) {})
+	world.players.ChangeById(playerId, func(p *Player) {})
 
+	last := "" // the last line, for repeatLine
 	for {
-		message, error := getString(player.connection)
+		message, error := getString(c)
 		if error != nil {
+			disconnectPlayer(&world, playerId, c)
 			return
 		}
-
-		messageArgs := strings.Split(message, " ")
-		var trimmedMessageArgs []string // accomodates extra spaces between args
-		for _, arg := range messageArgs {
-			trimmedArg := strings.Trim(arg, " ")
-			if trimmedArg != "" {
-				trimmedMessageArgs = append(trimmedMessageArgs, trimmedArg)
-			}
-		}
-
-		if len(trimmedMessageArgs) == 0 {
-			player.Write(commandRejectMessage + "1")
-			continue
-		}
-		commandString := strings.ToLower(trimmedMessageArgs[0])
-		if len(trimmedMessageArgs) > 1 {
-			trimmedMessageArgs = trimmedMessageArgs[1:]
+		if player, exists = world.players.GetById(playerId); !exists || player.connection != c {
+			return // another connection took over the player
 		}
-		if command, commandExists := commands[commandString]; !commandExists {
-			player.Write(commandRejectMessage + "2")
-		} else {
-			command(trimmedMessageArgs, playerId, &world)
+		if strings.TrimSpace(message) == repeatLine {
+			if last == "" {
+				player.Write("There is no command to repeat.")
+				continue
+			}
+			message = last
+		} else if strings.TrimSpace(message) != "" {
+			last = message
 		}
+		runLine(message, playerId, c, &world)
 	}
 }
 
@@ -219,6 +346,8 @@ func handlePlayer(world World, playerId identifier) {
 func getBytesSecure(c net.Conn) ([]byte, error) {
 	readBuf := make([]byte, 8)
 	finalBuf := make([]byte, 0)
+	maxLine := maxLineLength(c)
+	tooLong := false // the current line is too long, and is being discarded
 	for {
 		n, err := c.Read(readBuf)
 		if err != nil {
@@ -230,21 +359,31 @@ func getBytesSecure(c net.Conn) ([]byte, error) {
 				return nil, errors.New("connection closed")
 			}
 		}
-		readBuf = bytes.Trim(readBuf[:n], "\x00")
-		if len(readBuf) == 0 {
+		chunk := bytes.Trim(readBuf[:n], "\x00")
+		if len(chunk) == 0 {
 			continue
 		}
-		readBuf = handleTelnet(readBuf, c)
-		if len(readBuf) == 0 {
+		finalBuf = append(finalBuf, chunk...)
+		if len(finalBuf) == 0 {
 			continue
 		}
-		finalBuf = append(finalBuf, readBuf...)
-		if len(finalBuf) == 0 {
+		endsLine := finalBuf[len(finalBuf)-1] == '\n'
+		if len(finalBuf) > maxLine {
+			tooLong = true
+			for i := range finalBuf {
+				finalBuf[i] = 0
+			}
+			finalBuf = finalBuf[:0]
+		}
+		if !endsLine {
 			continue
 		}
-		if finalBuf[len(finalBuf)-1] == '\n' {
-			break
+		if tooLong {
+			c.Write([]byte("That line was too long, and was ignored.\r\n"))
+			tooLong = false
+			continue
 		}
+		break
 	}
 	for i := range readBuf {
 		readBuf[i] = 0
@@ -262,6 +401,8 @@ func getString(c net.Conn) (string, error) {
 
 	readBuf := make([]byte, 8)
 	finalBuf := make([]byte, 0)
+	maxLine := maxLineLength(c)
+	tooLong := false // the current line is too long, and is being discarded
 	for {
 		n, err := c.Read(readBuf)
 		if err != nil {
@@ -273,21 +414,31 @@ func getString(c net.Conn) (string, error) {
 				return "", errors.New("connection closed")
 			}
 		}
-		readBuf = bytes.Trim(readBuf[:n], "\x00")
-		if len(readBuf) == 0 {
+		chunk := bytes.Trim(readBuf[:n], "\x00")
+		if len(chunk) == 0 {
 			continue
 		}
-		readBuf = handleTelnet(readBuf, c)
-		if len(readBuf) == 0 {
+		finalBuf = append(finalBuf, chunk...)
+		if len(finalBuf) == 0 {
 			continue
 		}
-		finalBuf = append(finalBuf, readBuf...)
-		if len(finalBuf) == 0 {
+		endsLine := finalBuf[len(finalBuf)-1] == '\n'
+		if len(finalBuf) > maxLine {
+			tooLong = true
+			for i := range finalBuf {
+				finalBuf[i] = 0
+			}
+			finalBuf = finalBuf[:0]
+		}
+		if !endsLine {
 			continue
 		}
-		if finalBuf[len(finalBuf)-1] == '\n' {
-			break
+		if tooLong {
+			c.Write([]byte("That line was too long, and was ignored.\r\n"))
+			tooLong = false
+			continue
 		}
+		break
 	}
 	finalBuf = bytes.Trim(finalBuf, " \r\n")
 	fi.WriteString(string(finalBuf))
@@ -295,33 +446,67 @@ func getString(c net.Conn) (string, error) {
 	return string(finalBuf), nil
 }
 
-// listen for new connections, and spin them off into goroutines
-func listen(world World) {
-	port := defaultPort
-	if len(os.Args) > 1 {
-		argPort, success := strconv.Atoi(os.Args[1])
-		if success != nil {
-			port = defaultPort
-		} else if port < 0 || port > 65535 {
-			port = defaultPort
-		} else {
-			port = argPort
-		}
-	}
-	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
-	if err != nil {
-		fmt.Println("error: " + err.Error())
+// handleConnection greets a new connection, telnet or web, and starts its login
+func handleConnection(world World, config Config, conn net.Conn) {
+	if ban, banned := siteBanned(remoteIP(conn)); banned {
+		conn.Write([]byte("Your site is banned: " + ban.Reason + "\r\n"))
+		conn.Close()
 		return
 	}
-	fmt.Println("running at " + ln.Addr().String())
+	c := NewSession(conn, config)
+	if refusal := admitSession(c, config); refusal != "" {
+		c.Write([]byte(refusal))
+		c.Close()
+		return
+	}
+	c.Write([]byte("gomud version " + version + "\r\n"))
+	c.Write([]byte("Welcome to gomud. "))
+	go handleLogin(world, c)
+}
+
+// handleTelnet finishes the TLS handshake, if it's a TLS connection, negotiates telnet options, and starts the login.
+// It's run in the connection's own goroutine, because anything written to the client may block until the client reads.
+func handleTelnet(world World, config Config, conn net.Conn) {
+	if err := tlsHandshake(conn); err != nil {
+		fmt.Println("TLS handshake error: " + err.Error())
+		conn.Close()
+		return
+	}
+	telnetConn := negotiateTelnet(conn, &world)
+	handleConnection(world, config, telnetConn)
+}
+
+// acceptTelnet accepts telnet connections, plain or TLS, and spins them off into goroutines
+func acceptTelnet(world World, config Config, ln net.Listener) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if listenersClosed() {
+				return
+			}
 			fmt.Println(err)
 			continue
 		}
-		conn.Write([]byte("gomud version " + version + "\r\n"))
-		conn.Write([]byte("Welcome to gomud. "))
-		go handleLogin(world, conn)
+		waitWhilePaused()
+		go handleTelnet(world, config, conn)
 	}
 }
+
+// listen for new connections, and spin them off into goroutines
+func listen(world World, config Config) {
+	if config.WebPort != 0 {
+		go listenWeb(world, config)
+	}
+	if config.TLSPort != 0 {
+		go listenTLS(world, config)
+	}
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(config.Port))
+	if err != nil {
+		fmt.Println("error: " + err.Error())
+		return
+	}
+	addListener(ln)
+	fmt.Println("running at " + ln.Addr().String())
+	restoreCopyover(&world, config)
+	acceptTelnet(world, config, ln)
+}
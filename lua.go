@@ -17,6 +17,7 @@ func funcs(world *World, npcId identifier) map[string]lua.Function {
 		"gomud_randomMove":   luaRandomMoveFunc(world, npcId),
 		"gomud_getPlayer":    luaGetPlayerFunc(world, npcId),
 		"gomud_attackPlayer": luaAttackPlayerFunc(world, npcId),
+		"gomud_say":          luaSayFunc(world, npcId),
 	}
 }
 
@@ -221,18 +222,79 @@ func luaRandomMoveFunc(world *World, selfId identifier) lua.Function {
 }
 
 // luaPushPlayer pushes a Player object (table) to the stack (function return)
-func luaPushPlayer(l *lua.State, player *Player) {
+// It takes the player's fields rather than the Player, so scripts running after the Player is released don't read it.
+func luaPushPlayer(l *lua.State, id identifier, name string) {
 	l.NewTable()
 
 	l.PushString("id")
-	l.PushInteger(int(player.Id()))
+	l.PushInteger(int(id))
 	l.SetTable(-3)
 
 	l.PushString("name")
-	l.PushString(player.Name())
+	l.PushString(name)
 	l.SetTable(-3)
 }
 
+// luaPushItem pushes an Item object (table) to the stack (function return)
+func luaPushItem(l *lua.State, id identifier, name string, brief string) {
+	l.NewTable()
+
+	l.PushString("id")
+	l.PushInteger(int(id))
+	l.SetTable(-3)
+
+	l.PushString("name")
+	l.PushString(name)
+	l.SetTable(-3)
+
+	l.PushString("brief")
+	l.PushString(brief)
+	l.SetTable(-3)
+}
+
+// luaSayFunc makes the NPC say something to the room it's in
+// Parameters:
+//   text string
+// Example test lua:
+//   gomud_say("Thank you, " .. giver.name .. "!")
+func luaSayFunc(world *World, npcId identifier) lua.Function {
+	return func(l *lua.State) int {
+		n := l.Top() // Number of arguments.
+		if n != 1 {
+			l.PushString("incorrect number of arguments: expected 1 got " + strconv.Itoa(n))
+			l.Error() // panics
+		}
+
+		text, ok := l.ToString(1)
+		if !ok {
+			l.PushString("incorrect argument: expected string")
+			l.Error() // panics
+		}
+
+		self, ok := world.npcs.GetById(npcId)
+		if !ok {
+			fmt.Println("luaSay got nonexistent npc '" + npcId.String() + "'")
+			l.PushString("error: self not found")
+			l.Error() // panics
+		}
+
+		if self.LocationType != ilRoom {
+			// NPCs being carried can't be heard
+			return 0
+		}
+
+		room, ok := world.rooms.GetById(self.Location)
+		if !ok {
+			fmt.Println("luaSay npc room not found '" + npcId.String() + "'")
+			l.PushString("error: self room not found")
+			l.Error() // panics
+		}
+
+		room.Write(Pink+ToProper(self.Name())+" says, \""+ToSentence(text)+"\""+Reset, *world.players)
+		return 0
+	}
+}
+
 // luaGetPlayerFunc returns a Player object for the requested player name.
 // Parameters:
 //   name string
@@ -263,7 +325,7 @@ func luaGetPlayerFunc(world *World, npcId identifier) lua.Function {
 			l.Error() // panics
 		}
 
-		luaPushPlayer(l, player)
+		luaPushPlayer(l, player.Id(), player.Name())
 		return 1
 	}
 }
@@ -35,7 +35,7 @@ const NotChaining = ChainTime(0)
 var NextId chan identifier // @todo make local, and passed to ThingManagers, which make it accessible
 var CurrentId chan identifier
 
-func NewWorld() *World {
+func NewWorld(config Config) *World {
 	go func() {
 		NextChainTime = make(chan ChainTime)
 		chainTime := ChainTime(0)
@@ -73,8 +73,11 @@ func NewWorld() *World {
 		rooms:   &rm,
 		npcs:    &nm,
 		items:   &im,
+		config:  config,
 	}
 	initDb(world)
+	world.owner = findOwnerAccount(world)
+	go publishChanges(world)
 
 	_, exists := RoomManager(*world.rooms).GetById(0)
 	if !exists {
@@ -86,6 +89,7 @@ func NewWorld() *World {
 			Exits:       make(map[Direction]identifier),
 			Players:     make(map[identifier]bool),
 			Items:       make(map[identifier]PlayerItemType),
+			Extras:      make(map[string]string),
 		})
 	}
 
@@ -154,8 +158,13 @@ func debug() {
 */
 
 func main() {
-	world := NewWorld()
+	config := parseConfig()
+	world := NewWorld(config)
 	//	world.script.Eval("mud_println('javascript engine running');")
 	fmt.Println("version " + version)
-	listen(*world)
+	go handleSignals(world, config.ShutdownDelay)
+	listen(*world, config)
+	if listenersClosed() {
+		select {} // shutting down; shutdown exits once everything is saved
+	}
 }
@@ -0,0 +1,85 @@
+/*
+mccp.go implements the MUD Client Compression Protocol v2 (MCCP2, telnet option 86).
+
+When the client agrees to MCCP2 (IAC DO MCCP2), we send IAC SB MCCP2 IAC SE,
+and everything written to the client after that is a zlib stream.
+Commands code doesn't need to know; TelnetConn.Write compresses transparently.
+
+Compression ends when the client asks (IAC DONT MCCP2), or the connection closes,
+at which point the zlib stream is finished, so the client sees a clean end of stream.
+*/
+package main
+
+import (
+	"compress/zlib"
+)
+
+const optMCCP2 = 86
+
+// flushingWriter flushes the zlib stream after every write, so the client gets output immediately.
+type flushingWriter struct {
+	*zlib.Writer
+}
+
+func (w flushingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Writer.Flush()
+}
+
+// startCompression begins the compressed stream, after anything already queued. Called when the client agrees to MCCP2.
+func (t *TelnetConn) startCompression() {
+	queue := t.getQueue()
+	if queue == nil {
+		t.beginCompression()
+		return
+	}
+	queue(t.beginCompression, true)
+}
+
+// beginCompression sends the start of the compressed stream, and compresses everything written after it.
+func (t *TelnetConn) beginCompression() error {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	if t.compressor != nil {
+		return nil
+	}
+	if _, err := t.out.Write([]byte{IAC, SB, optMCCP2, IAC, SE}); err != nil {
+		return err
+	}
+	t.compressor = zlib.NewWriter(t.Conn)
+	t.out = flushingWriter{t.compressor}
+	return nil
+}
+
+// stopCompression ends the compressed stream, if there is one. Later writes are uncompressed.
+func (t *TelnetConn) stopCompression() {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	if t.compressor == nil {
+		return
+	}
+	t.compressor.Close() // an error means the connection is gone, and there's no one to tell
+	t.compressor = nil
+	t.out = t.Conn
+}
+
+// Compressed returns whether output to the client is currently compressed.
+func (t *TelnetConn) Compressed() bool {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	return t.compressor != nil
+}
+
+func (t *TelnetConn) handleMCCP2(option byte, local bool, enabled bool) {
+	if !local {
+		return
+	}
+	if enabled {
+		t.startCompression()
+	} else {
+		t.stopCompression()
+	}
+}
@@ -0,0 +1,302 @@
+/*
+msdp.go implements the Mud Server Data Protocol (MSDP, telnet option 69).
+
+Clients send commands like IAC SB MSDP MSDP_VAR "REPORT" MSDP_VAL "HEALTH" IAC SE.
+REPORT asks us to send a variable now, and again whenever it changes. UNREPORT stops that.
+SEND sends a variable once. LIST lists commands and variables. RESET clears the reported variables.
+
+Variables come from the connection's player, which is set when the player logs in.
+Changes are pushed by publishChanges, in oob.go.
+*/
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const optMSDP = 69
+
+const (
+	msdpVar        = 1
+	msdpVal        = 2
+	msdpTableOpen  = 3
+	msdpTableClose = 4
+	msdpArrayOpen  = 5
+	msdpArrayClose = 6
+)
+
+var msdpCommands = []string{"LIST", "REPORT", "RESET", "SEND", "UNREPORT"}
+
+var msdpReportable = []string{
+	"CHARACTER_NAME",
+	"HEALTH",
+	"HEALTH_MAX",
+	"LEVEL",
+	"MANA",
+	"MANA_MAX",
+	"ROOM_EXITS",
+	"ROOM_NAME",
+	"ROOM_VNUM",
+}
+
+// MSDPSource returns the current values of the MSDP variables for a connection.
+// Values are strings, or map[string]string for tables.
+type MSDPSource func() map[string]interface{}
+
+// msdpState is the MSDP state of a single connection.
+type msdpState struct {
+	source   MSDPSource
+	reported map[string]bool
+	sent     map[string]string // variable to the last encoded value sent
+}
+
+// SetMSDPSource sets where the connection's MSDP variables come from, and reports anything already requested.
+func (t *TelnetConn) SetMSDPSource(source MSDPSource) {
+	t.optionsMutex.Lock()
+	t.msdp.source = source
+	t.msdp.sent = nil
+	t.optionsMutex.Unlock()
+	t.ReportMSDP()
+}
+
+// msdpEncodeValue encodes a single MSDP value, without the MSDP_VAL which precedes it.
+func msdpEncodeValue(buffer *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		buffer.WriteString(v)
+	case []string:
+		buffer.WriteByte(msdpArrayOpen)
+		for _, s := range v {
+			buffer.WriteByte(msdpVal)
+			buffer.WriteString(s)
+		}
+		buffer.WriteByte(msdpArrayClose)
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buffer.WriteByte(msdpTableOpen)
+		for _, k := range keys {
+			buffer.WriteByte(msdpVar)
+			buffer.WriteString(k)
+			buffer.WriteByte(msdpVal)
+			buffer.WriteString(v[k])
+		}
+		buffer.WriteByte(msdpTableClose)
+	}
+}
+
+func msdpEncode(name string, value interface{}) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(msdpVar)
+	buffer.WriteString(name)
+	buffer.WriteByte(msdpVal)
+	msdpEncodeValue(&buffer, value)
+	return buffer.Bytes()
+}
+
+// msdpParse parses a client MSDP message into variables and their values. Arrays are flattened into the values.
+func msdpParse(data []byte) map[string][]string {
+	vars := map[string][]string{}
+	var name string
+	var value []byte
+	inValue := false
+	endValue := func() {
+		if inValue && name != "" {
+			vars[name] = append(vars[name], string(value))
+		}
+		value = value[:0]
+		inValue = false
+	}
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case msdpVar:
+			endValue()
+			j := i + 1
+			for j < len(data) && data[j] > msdpArrayClose {
+				j++
+			}
+			name = strings.ToUpper(string(data[i+1 : j]))
+			if _, ok := vars[name]; !ok {
+				vars[name] = nil
+			}
+			i = j - 1
+		case msdpVal:
+			endValue()
+			inValue = true
+		case msdpArrayOpen, msdpTableOpen:
+			// the VAL before an array opens it, and isn't a value itself
+			value = value[:0]
+			inValue = false
+		case msdpArrayClose, msdpTableClose:
+			endValue()
+		default:
+			if inValue {
+				value = append(value, data[i])
+			}
+		}
+	}
+	endValue()
+	return vars
+}
+
+// handleMSDP handles an MSDP message from the client.
+func (t *TelnetConn) handleMSDP(option byte, data []byte) {
+	for command, values := range msdpParse(data) {
+		switch command {
+		case "LIST":
+			for _, list := range values {
+				t.msdpList(strings.ToUpper(list))
+			}
+		case "REPORT":
+			t.optionsMutex.Lock()
+			if t.msdp.reported == nil {
+				t.msdp.reported = make(map[string]bool)
+			}
+			for _, v := range values {
+				t.msdp.reported[strings.ToUpper(v)] = true
+				delete(t.msdp.sent, strings.ToUpper(v)) // send it now, even if unchanged
+			}
+			t.optionsMutex.Unlock()
+			t.ReportMSDP()
+		case "UNREPORT":
+			t.optionsMutex.Lock()
+			for _, v := range values {
+				delete(t.msdp.reported, strings.ToUpper(v))
+				delete(t.msdp.sent, strings.ToUpper(v))
+			}
+			t.optionsMutex.Unlock()
+		case "RESET":
+			t.optionsMutex.Lock()
+			t.msdp.reported = nil
+			t.msdp.sent = nil
+			t.optionsMutex.Unlock()
+		case "SEND":
+			t.sendMSDP(values)
+		}
+	}
+}
+
+func (t *TelnetConn) msdpList(list string) {
+	var items []string
+	switch list {
+	case "COMMANDS":
+		items = msdpCommands
+	case "LISTS":
+		items = []string{"COMMANDS", "LISTS", "REPORTABLE_VARIABLES", "REPORTED_VARIABLES"}
+	case "REPORTABLE_VARIABLES":
+		items = msdpReportable
+	case "REPORTED_VARIABLES":
+		t.optionsMutex.Lock()
+		for v := range t.msdp.reported {
+			items = append(items, v)
+		}
+		t.optionsMutex.Unlock()
+		sort.Strings(items)
+	default:
+		return
+	}
+	t.Subnegotiate(optMSDP, msdpEncode(list, items))
+}
+
+// msdpValues returns the current values from the connection's source, or nil if there's no player yet.
+func (t *TelnetConn) msdpValues() map[string]interface{} {
+	t.optionsMutex.Lock()
+	source := t.msdp.source
+	t.optionsMutex.Unlock()
+	if source == nil {
+		return nil
+	}
+	return source()
+}
+
+// sendMSDP sends the given variables once, whether or not they changed.
+func (t *TelnetConn) sendMSDP(names []string) {
+	values := t.msdpValues()
+	var data []byte
+	for _, name := range names {
+		value, ok := values[strings.ToUpper(name)]
+		if !ok {
+			continue
+		}
+		data = append(data, msdpEncode(strings.ToUpper(name), value)...)
+	}
+	if len(data) > 0 {
+		t.Subnegotiate(optMSDP, data)
+	}
+}
+
+// ReportMSDP sends the reported variables which changed since they were last sent.
+func (t *TelnetConn) ReportMSDP() {
+	if !t.Local(optMSDP) {
+		return
+	}
+	values := t.msdpValues()
+	if values == nil {
+		return
+	}
+	var data []byte
+	t.optionsMutex.Lock()
+	if t.msdp.sent == nil {
+		t.msdp.sent = make(map[string]string)
+	}
+	for name := range t.msdp.reported {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		encoded := msdpEncode(name, value)
+		if t.msdp.sent[name] == string(encoded) {
+			continue
+		}
+		t.msdp.sent[name] = string(encoded)
+		data = append(data, encoded...)
+	}
+	t.optionsMutex.Unlock()
+	if len(data) > 0 {
+		t.Subnegotiate(optMSDP, data)
+	}
+}
+
+// msdpVariables returns the MSDP variables for the given player.
+func msdpVariables(player *Player, world *World) map[string]interface{} {
+	values := map[string]interface{}{
+		"CHARACTER_NAME": ToProper(player.Name()),
+		"HEALTH":         strconv.FormatUint(uint64(player.health), 10),
+		"HEALTH_MAX":     strconv.FormatUint(uint64(player.MaxHealth()), 10),
+		"LEVEL":          strconv.FormatUint(uint64(player.level), 10),
+		"MANA":           strconv.FormatUint(uint64(player.mana), 10),
+		"MANA_MAX":       strconv.FormatUint(uint64(player.MaxMana()), 10),
+		"ROOM_VNUM":      player.Room.String(),
+	}
+	if room, ok := world.rooms.GetById(player.Room); ok {
+		values["ROOM_NAME"] = room.Name()
+		exits := map[string]string{}
+		for direction, id := range room.Exits {
+			exits[direction.String()] = id.String()
+		}
+		values["ROOM_EXITS"] = exits
+	}
+	return values
+}
+
+// bindMSDP makes the player the source of the connection's MSDP variables.
+func bindMSDP(c net.Conn, playerId identifier, world *World) {
+	t, ok := telnetOf(c)
+	if !ok {
+		return
+	}
+	t.SetMSDPSource(func() map[string]interface{} {
+		player, ok := world.players.GetById(playerId)
+		if !ok {
+			return nil
+		}
+		return msdpVariables(player, world)
+	})
+}
@@ -0,0 +1,79 @@
+/*
+mssp.go implements the Mud Server Status Protocol (MSSP, telnet option 70).
+
+MUD listing crawlers connect, ask for MSSP (IAC DO MSSP), and we reply with
+IAC SB MSSP MSSP_VAR "name" MSSP_VAL "value" ... IAC SE, describing the server.
+*/
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+const optMSSP = 70
+
+const (
+	msspVar = 1
+	msspVal = 2
+)
+
+// startTime is when the server started, for the MSSP uptime.
+var startTime = time.Now()
+
+// msspVariable is a single MSSP variable. Order matters to some crawlers, which expect NAME first.
+type msspVariable struct {
+	name  string
+	value string
+}
+
+func msspVariables(world *World) []msspVariable {
+	return []msspVariable{
+		{"NAME", "gomud"},
+		{"CODEBASE", "gomud " + version},
+		{"PLAYERS", strconv.Itoa(onlinePlayers())},
+		{"UPTIME", strconv.FormatInt(startTime.Unix(), 10)},
+		{"ROOMS", strconv.Itoa(ThingManager(*world.rooms).Count())},
+		{"OBJECTS", strconv.Itoa(ThingManager(*world.items).Count())},
+		{"MOBILES", strconv.Itoa(ThingManager(*world.npcs).Count())},
+		{"ANSI", "1"},
+		{"UTF-8", "1"},
+		{"MCCP", "1"},
+		{"GMCP", "1"},
+		{"MSDP", "1"},
+		{"MSSP", "1"},
+	}
+}
+
+// onlinePlayers returns the number of players connected. Link-dead players, who are still loaded, aren't counted.
+func onlinePlayers() int {
+	online := map[identifier]bool{}
+	for _, s := range Sessions() {
+		if id := s.Player(); id != invalidIdentifier {
+			online[id] = true
+		}
+	}
+	return len(online)
+}
+
+// sendMSSP sends the server status to the client.
+func sendMSSP(t *TelnetConn, world *World) {
+	var data []byte
+	for _, v := range msspVariables(world) {
+		data = append(data, msspVar)
+		data = append(data, v.name...)
+		data = append(data, msspVal)
+		data = append(data, v.value...)
+	}
+	t.Subnegotiate(optMSSP, data)
+}
+
+// supportMSSP sends the server status whenever the client asks for MSSP, or accepts our offer of it.
+func supportMSSP(t *TelnetConn, world *World) {
+	t.SupportLocal(optMSSP, true)
+	t.OnOption(optMSSP, func(option byte, local bool, enabled bool) {
+		if local && enabled {
+			sendMSSP(t, world)
+		}
+	})
+}
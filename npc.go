@@ -12,6 +12,7 @@ package main
 import (
 	"fmt"
 	"github.com/Shopify/go-lua"
+	"strings"
 )
 
 /// Animation:
@@ -29,11 +30,13 @@ type Npc struct {
 	id           identifier
 	name         string
 	Brief        string
+	Description  string // what players see when they look at the NPC
 	Sleeping     bool
 	Dna          string
 	Location     identifier
 	LocationType ItemLocationType    ///< @todo ? remove this ? it isn't strictly necessary, as we can type assert to find the type
 	Items        map[identifier]bool // true = npc, false = item
+	Hooks        map[string]string   // scripts run when things happen to the NPC, by event. See Hook
 }
 
 func (n *Npc) Id() identifier {
@@ -48,6 +51,11 @@ func (n *Npc) Name() string {
 	return n.name
 }
 
+// Keywords returns the words players can refer to the NPC by.
+func (n *Npc) Keywords() []string {
+	return strings.Fields(strings.ToLower(n.name))
+}
+
 func (n *Npc) selfWrappedDna() string {
 	return "(function(self) {" + n.Dna + "})(" + n.id.String() + ")"
 }
@@ -60,6 +68,8 @@ func (n *Npc) Animate(world *World) {
 	n.Sleeping = false
 	go func() {
 		fmt.Printf("Animating %s\n", n.id.String())
+		worldPause.RLock()
+		defer worldPause.RUnlock()
 		err := lua.DoString(initLua(world, n.id), n.Dna)
 		if err != nil {
 			fmt.Printf("npc.Animate error with %s: %v\n", n.id.String(), err)
@@ -67,6 +77,35 @@ func (n *Npc) Animate(world *World) {
 	}()
 }
 
+// npcHookGive is the event of a player giving the NPC something.
+// The hook's script gets the globals 'giver', with the player's id and name, and 'item', with the thing's id, name and brief.
+const npcHookGive = "give"
+
+// npcHooks are the events NPCs can have hooks for.
+var npcHooks = []string{npcHookGive}
+
+// Hook runs the NPC's script for the event, if it has one, with the globals set.
+// Like Animate, it returns immediately, so the caller may hold things the script uses.
+func (n *Npc) Hook(world *World, event string, globals map[string]func(l *lua.State)) {
+	script, ok := n.Hooks[event]
+	if !ok || script == "" {
+		return
+	}
+	id := n.id
+	go func() {
+		worldPause.RLock()
+		defer worldPause.RUnlock()
+		l := initLua(world, id)
+		for name, push := range globals {
+			push(l)
+			l.SetGlobal(name)
+		}
+		if err := lua.DoString(l, script); err != nil {
+			fmt.Printf("npc.Hook %s error with %s: %v\n", event, id.String(), err)
+		}
+	}()
+}
+
 type NpcManager ThingManager
 
 /// @todo remove this, after changing things which call it to store Accessors rather than IDs
@@ -0,0 +1,77 @@
+/*
+oob.go publishes out-of-band data to clients.
+
+Clients which speak GMCP or MSDP get the player's vitals, room and inventory
+pushed to them whenever the Things they describe change, as well as on login.
+Changes come from the ThingManagers' Changes chans, as snapshots, so publishing never reads a Thing a setter is changing.
+Messages are queued on each player's Session, like text, so a client which stops reading can't hold up the publisher.
+*/
+package main
+
+// publisher is the state of publishChanges: the latest snapshot of each connected player and changed room,
+// and the GMCP messages last sent to each player. Rooms are only known once they change; handlePlayer changes the player's room,
+// so it's known on login.
+type publisher struct {
+	world   *World
+	players map[identifier]*Player
+	rooms   map[identifier]*Room
+	sent    map[identifier]*gmcpSent
+}
+
+// publishPlayer pushes the player's state to their client, over whichever out-of-band protocols it uses.
+// A player with no connection has disconnected, and is forgotten.
+func (p *publisher) publishPlayer(player *Player) {
+	if player.connection == nil {
+		delete(p.players, player.Id())
+		delete(p.sent, player.Id())
+		return
+	}
+	p.players[player.Id()] = player
+	sendIfChanged(player, p.sent, "Char.Vitals", gmcpVitals(player))
+	sendIfChanged(player, p.sent, "Char.Items.List", gmcpItems(player, p.world))
+	if room, ok := p.rooms[player.Room]; ok {
+		sendIfChanged(player, p.sent, "Room.Info", gmcpRoomInfo(room))
+	}
+	if t, ok := telnetOf(player.connection); ok {
+		t.ReportMSDP()
+	}
+}
+
+// publishChanges reads snapshots of changed Things from the managers, and pushes them to the clients they concern.
+// It only reads snapshots, never the Things themselves, which setters may be changing.
+func publishChanges(world *World) {
+	p := &publisher{
+		world:   world,
+		players: map[identifier]*Player{},
+		rooms:   map[identifier]*Room{},
+		sent:    map[identifier]*gmcpSent{},
+	}
+	playerChanges := ThingManager(*world.players).Changes()
+	roomChanges := ThingManager(*world.rooms).Changes()
+	itemChanges := ThingManager(*world.items).Changes()
+	for {
+		select {
+		case t := <-playerChanges:
+			p.publishPlayer(t.(*Player))
+		case t := <-roomChanges:
+			room := t.(*Room)
+			p.rooms[room.Id()] = room
+			for id := range room.Players {
+				if player, ok := p.players[id]; ok {
+					sendIfChanged(player, p.sent, "Room.Info", gmcpRoomInfo(room))
+					if t, ok := telnetOf(player.connection); ok {
+						t.ReportMSDP()
+					}
+				}
+			}
+		case t := <-itemChanges:
+			item := t.(*Item)
+			if item.LocationType != ilPlayer {
+				continue
+			}
+			if player, ok := p.players[item.Location]; ok {
+				sendIfChanged(player, p.sent, "Char.Items.List", gmcpItems(player, world))
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+/*
+oob_test.go tests that published snapshots don't share state with their Things, and that the publisher forgets disconnected players.
+*/
+package main
+
+import (
+	"testing"
+)
+
+func TestSnapshotsCopyMaps(t *testing.T) {
+	player := &Player{id: 1, Items: map[identifier]PlayerItemType{2: piItem}}
+	playerSnapshot := player.Snapshot().(*Player)
+	player.Items[3] = piNpc
+	delete(player.Items, 2)
+	if len(playerSnapshot.Items) != 1 || playerSnapshot.Items[2] != piItem {
+		t.Errorf("player snapshot items changed to %v", playerSnapshot.Items)
+	}
+
+	room := &Room{id: 4, Players: map[identifier]bool{1: true}, Exits: map[Direction]identifier{}}
+	roomSnapshot := room.Snapshot().(*Room)
+	room.Players[5] = true
+	if len(roomSnapshot.Players) != 1 {
+		t.Errorf("room snapshot players changed to %v", roomSnapshot.Players)
+	}
+}
+
+func TestPublisherForgetsDisconnectedPlayers(t *testing.T) {
+	p := &publisher{
+		players: map[identifier]*Player{1: {id: 1}},
+		rooms:   map[identifier]*Room{},
+		sent:    map[identifier]*gmcpSent{1: {}},
+	}
+	p.publishPlayer(&Player{id: 1})
+	if len(p.players) != 0 || len(p.sent) != 0 {
+		t.Errorf("disconnected player still known: %v %v", p.players, p.sent)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+parser.go parses the lines players type into commands, and runs them.
+
+A line may contain several commands, separated by ';', e.g. "get sword;n;look". A line of just '!' repeats the last line.
+The first word of a command is the verb. If it's one of the player's aliases, it's expanded first; see aliases.go. A verb which isn't a letter or digit is a command by itself, so "'hello" says hello.
+Verbs match a command's name or alias exactly, or a social's or channel's name (see socials.go and channels.go),
+or else the start of a command's name. When several names start with a verb,
+the first in the commands table wins, so the table is ordered by priority. Commands the player may not use never match.
+
+Arguments are separated by spaces. Double quotes group words into one argument, e.g. get "long sword".
+Commands which take free text, such as say, are Raw: they take the rest of the line as typed, quotes and separators included.
+*/
+package main
+
+import (
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	commandSeparator = ';'
+	repeatLine       = "!"
+)
+
+type CommandFunc func([]string, identifier, *World)
+
+// Command is a command players can type, and the role they need to use it.
+type Command struct {
+	Name      string
+	Aliases   []string
+	MinArgs   int    // commands given fewer arguments are rejected with their usage
+	Usage     string // the arguments the command takes, e.g. "person message"
+	Role      Role
+	Raw       bool // the command takes the rest of the line as typed, without quoting or separators
+	Exact     bool // the command must be typed in full, because it's dangerous to run by accident
+	Protected bool // players can't define aliases which hide the command
+	Func      CommandFunc
+}
+
+// commands is the command table, in priority order for prefix matching. It's filled by initCommands.
+var commands []Command
+
+// Syntax returns how the command is typed, e.g. "tell person message".
+func (c *Command) Syntax() string {
+	if c.Usage == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Usage
+}
+
+// findCommand returns the command the verb means, for a player with the role.
+func findCommand(verb string, role Role) (*Command, bool) {
+	for i := range commands {
+		command := &commands[i]
+		if role < command.Role {
+			continue
+		}
+		if command.Name == verb {
+			return command, true
+		}
+		for _, alias := range command.Aliases {
+			if alias == verb {
+				return command, true
+			}
+		}
+	}
+	if command := socialCommand(verb); command != nil {
+		return command, true
+	}
+	if command := channelCommand(verb, role); command != nil {
+		return command, true
+	}
+	for i := range commands {
+		command := &commands[i]
+		if role < command.Role || command.Exact {
+			continue
+		}
+		if strings.HasPrefix(command.Name, verb) {
+			return command, true
+		}
+	}
+	return nil, false
+}
+
+// parsedCommand is a command in a line. command is nil if the verb didn't match one.
+type parsedCommand struct {
+	verb    string
+	command *Command
+	args    []string
+}
+
+// parseLine splits the line into commands, for a player with the role and aliases.
+func parseLine(line string, role Role, aliases map[string]string) ([]parsedCommand, error) {
+	var parsed []parsedCommand
+	err := parseInto(&parsed, line, role, aliases, 0)
+	return parsed, err
+}
+
+// parseInto appends the commands in the line to parsed. depth is how many aliases the line was expanded from.
+func parseInto(parsed *[]parsedCommand, line string, role Role, aliases map[string]string, depth int) error {
+	rest := line
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+		if rest[0] == commandSeparator {
+			rest = rest[1:]
+			continue
+		}
+		verb, afterVerb := splitVerb(rest)
+		if expansion, ok := aliases[strings.ToLower(verb)]; ok {
+			if depth >= maxAliasDepth {
+				return errAliasDepth
+			}
+			var argString string
+			argString, rest = cutCommand(afterVerb)
+			if err := parseInto(parsed, expandAlias(expansion, argString), role, aliases, depth+1); err != nil {
+				return err
+			}
+			if len(*parsed) > maxAliasedCommands {
+				return errAliasCommands
+			}
+			continue
+		}
+		command, _ := findCommand(strings.ToLower(verb), role)
+		if command != nil && command.Raw {
+			*parsed = append(*parsed, parsedCommand{verb: verb, command: command, args: strings.Fields(afterVerb)})
+			break
+		}
+		var argString string
+		argString, rest = cutCommand(afterVerb)
+		*parsed = append(*parsed, parsedCommand{verb: verb, command: command, args: splitArgs(argString)})
+	}
+	return nil
+}
+
+// splitVerb returns the verb at the start of s, and the rest of s.
+func splitVerb(s string) (string, string) {
+	r, size := utf8.DecodeRuneInString(s)
+	if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		return s[:size], s[size:]
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == commandSeparator
+	})
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// cutCommand returns s up to the first separator which isn't quoted, and whatever follows the separator.
+func cutCommand(s string) (string, string) {
+	quoted := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == commandSeparator && !quoted:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// splitArgs splits s on spaces, except within double quotes. An unterminated quote runs to the end.
+func splitArgs(s string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg := false // so "" is an empty argument
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// runLine runs each command in the line the player typed, in order.
+// It stops at a command which doesn't exist, is given too few arguments, or is rate limited,
+// and if the player quits or is taken over by another connection.
+func runLine(line string, playerId identifier, c net.Conn, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		return
+	}
+	parsed, err := parseLine(line, player.Role(), player.Aliases)
+	if err != nil {
+		player.Write(err.Error())
+		return
+	}
+	if len(parsed) == 0 {
+		c.Write([]byte(player.Prompt()))
+		return
+	}
+	for i, p := range parsed {
+		if i > 0 {
+			if player, exists = world.players.GetById(playerId); !exists || player.connection != c || player.state == psQuitting {
+				return
+			}
+		}
+		if !throttle(c) {
+			return
+		}
+		if p.command == nil {
+			player.Write(commandRejectMessage) // players can't tell commands they may not use from ones which don't exist
+			return
+		}
+		if len(p.args) < p.command.MinArgs {
+			player.Write("Usage: " + p.command.Syntax())
+			return
+		}
+		worldPause.RLock() // a copyover waits for the command to finish, and holds off the next
+		p.command.Func(p.args, playerId, world)
+		worldPause.RUnlock()
+	}
+}
@@ -0,0 +1,136 @@
+/*
+parser_test.go tests splitting lines into commands, and the limits on expanding aliases.
+*/
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// parsedVerbs returns each parsed command's name, or its verb if it didn't match a command, followed by its args.
+func parsedVerbs(parsed []parsedCommand) [][]string {
+	var got [][]string
+	for _, p := range parsed {
+		name := "?" + p.verb
+		if p.command != nil {
+			name = p.command.Name
+		}
+		got = append(got, append([]string{name}, p.args...))
+	}
+	return got
+}
+
+func TestParseLine(t *testing.T) {
+	initCommands()
+	tests := []struct {
+		line    string
+		aliases map[string]string
+		want    [][]string
+	}{
+		{"look", nil, [][]string{{"look"}}},
+		{"get sword;n; ;look", nil, [][]string{{"get", "sword"}, {"north"}, {"look"}}},
+		{"nor", nil, [][]string{{"north"}}},
+		{"LOOK", nil, [][]string{{"look"}}},
+		{"xyzzy plugh", nil, [][]string{{"?xyzzy", "plugh"}}},
+		{`get "long sword";drop "a;b"`, nil, [][]string{{"get", "long sword"}, {"drop", "a;b"}}},
+		{`get ""`, nil, [][]string{{"get", ""}}},
+		{"say hello; look", nil, [][]string{{"say", "hello;", "look"}}},
+		{"'hello", nil, [][]string{{"say", "hello"}}},
+		{"kk", map[string]string{"kk": "get sword;drop shield"}, [][]string{{"get", "sword"}, {"drop", "shield"}}},
+		{"k bob;look", map[string]string{"k": "get $1"}, [][]string{{"get", "bob"}, {"look"}}},
+		{"a", map[string]string{"a": "b;b", "b": "look"}, [][]string{{"look"}, {"look"}}},
+	}
+	for _, test := range tests {
+		parsed, err := parseLine(test.line, rolePlayer, test.aliases)
+		if err != nil {
+			t.Errorf("%q: error %v", test.line, err)
+			continue
+		}
+		if got := parsedVerbs(parsed); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: parsed %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseLineRoles(t *testing.T) {
+	initCommands()
+	for _, command := range commands {
+		if command.Role == rolePlayer || command.Exact {
+			continue
+		}
+		parsed, err := parseLine(command.Name, rolePlayer, nil)
+		if err != nil || len(parsed) != 1 {
+			t.Fatalf("%q: parsed %v %v", command.Name, parsed, err)
+		}
+		if parsed[0].command != nil && parsed[0].command.Name == command.Name {
+			t.Errorf("%q: a player found the %v command", command.Name, command.Role)
+		}
+	}
+}
+
+// aliasChain returns aliases a0 to an, each of which expands the next, with the last expanding to look.
+func aliasChain(n int) map[string]string {
+	aliases := make(map[string]string)
+	for i := 0; i < n; i++ {
+		aliases["a"+strconv.Itoa(i)] = "a" + strconv.Itoa(i+1)
+	}
+	aliases["a"+strconv.Itoa(n)] = "look"
+	return aliases
+}
+
+func TestParseLineAliasLimits(t *testing.T) {
+	initCommands()
+	tests := []struct {
+		name    string
+		aliases map[string]string
+		err     error
+	}{
+		{"deepest chain", aliasChain(maxAliasDepth - 1), nil},
+		{"chain too deep", aliasChain(maxAliasDepth), errAliasDepth},
+		{"recursive", map[string]string{"a0": "look;a0"}, errAliasDepth},
+		{"mutually recursive", map[string]string{"a0": "a1", "a1": "a0"}, errAliasDepth},
+		{"most commands", map[string]string{"a0": "a1;a1;a1;a1;a1", "a1": "look;look;look;look;look;look;look;look;look;look"}, nil},
+		{"too many commands", map[string]string{"a0": "a1;a1;a1;a1;a1;a1", "a1": "look;look;look;look;look;look;look;look;look;look"}, errAliasCommands},
+		{"exponential", map[string]string{"a0": "a1;a1", "a1": "a2;a2", "a2": "a3;a3", "a3": "a4;a4", "a4": "a5;a5", "a5": "a6;a6", "a6": "look;look"}, errAliasCommands},
+	}
+	for _, test := range tests {
+		parsed, err := parseLine("a0", rolePlayer, test.aliases)
+		if err != test.err {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+		if err == nil && len(parsed) == 0 {
+			t.Errorf("%s: parsed nothing", test.name)
+		}
+	}
+}
+
+// TestFindCommandPrefixes checks the commands which short prefixes mean, which the order of the command table decides.
+func TestFindCommandPrefixes(t *testing.T) {
+	initCommands()
+	tests := []struct {
+		verb string
+		want string // empty if the verb should find no command
+	}{
+		{"h", "help"},
+		{"c", "close"},
+		{"d", "drop"},
+		{"de", "description"},
+		{"p", "put"},
+		{"po", "pose"},
+		{"pa", ""}, // password must be typed in full
+		{"hi", "history"},
+		{"ch", "channels"},
+		{"j", "join"},
+	}
+	for _, test := range tests {
+		name := ""
+		if command, ok := findCommand(test.verb, rolePlayer); ok {
+			name = command.Name
+		}
+		if name != test.want {
+			t.Errorf("%q found %q, want %q", test.verb, name, test.want)
+		}
+	}
+}
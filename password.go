@@ -0,0 +1,279 @@
+/*
+password.go hashes, checks and changes account passwords.
+
+Passwords are hashed with scrypt. The cost parameters are stored with each account, so they can be raised:
+when someone logs in with a password hashed with older parameters, it's rehashed with the current ones.
+
+Admins can reset a password, which gives them a one-time token for the account.
+The account must log in with the token, and choose a new password.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"net"
+	"strings"
+)
+
+// the scrypt parameters new hashes are created with
+const (
+	scryptN      = 16384
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 128
+)
+
+// ScryptParams are the scrypt cost parameters a password was hashed with.
+type ScryptParams struct {
+	N int
+	R int
+	P int
+}
+
+var currentScryptParams = ScryptParams{scryptN, scryptR, scryptP}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	n, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(salt) {
+		return nil, fmt.Errorf("short salt read %d", n)
+	}
+	return salt, nil
+}
+
+func hashPassword(pass []byte, salt []byte, params ScryptParams) ([]byte, error) {
+	return scrypt.Key(pass, salt, params.N, params.R, params.P, scryptKeyLen)
+}
+
+// checkPassword returns whether pass is the account's password.
+func checkPassword(account *Account, pass []byte) (bool, error) {
+	hashedPass, err := hashPassword(pass, account.passthesalt, account.scrypt)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashedPass, account.pass), nil
+}
+
+// setPassword hashes pass with a new salt and the current parameters, sets it as the account's password, and saves the account.
+func setPassword(world *World, account *Account, pass []byte) error {
+	salt, err := newSalt()
+	if err != nil {
+		return err
+	}
+	hashedPass, err := hashPassword(pass, salt, currentScryptParams)
+	if err != nil {
+		return err
+	}
+	account.pass = hashedPass
+	account.passthesalt = salt
+	account.scrypt = currentScryptParams
+	account.resetToken = ""
+	saveAccountPassword(world.db, account)
+	return nil
+}
+
+// rehashIfOutdated rehashes the account's password with the current parameters, if it was hashed with older ones.
+// It's called after a successful login, which is the only time we have the password.
+func rehashIfOutdated(world *World, account *Account, pass []byte) {
+	if account.scrypt == currentScryptParams {
+		return
+	}
+	if err := setPassword(world, account, pass); err != nil {
+		fmt.Println("rehash error: " + account.Login() + " " + err.Error())
+		return
+	}
+	fmt.Println("rehashed password for " + account.Login())
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// readPassword prompts for a password with hidden input.
+func readPassword(c net.Conn, prompt string) ([]byte, error) {
+	hideInput(c)
+	c.Write([]byte(prompt + "\r\n"))
+	pass, err := getBytesSecure(c)
+	showInput(c)
+	return pass, err
+}
+
+// readNewPassword prompts for a new password twice. It returns false if they don't match, or are empty.
+func readNewPassword(c net.Conn) ([]byte, bool, error) {
+	pass, err := readPassword(c, "Please enter your new password.")
+	if err != nil {
+		return nil, false, err
+	}
+	passVerify, err := readPassword(c, "Please verify your new password.")
+	defer zero(passVerify)
+	if err != nil {
+		zero(pass)
+		return nil, false, err
+	}
+	if len(pass) == 0 || !bytes.Equal(pass, passVerify) {
+		zero(pass)
+		return nil, false, nil
+	}
+	return pass, true, nil
+}
+
+//
+// reset tokens
+//
+
+// newResetToken returns a random token for the account to log in with, and the hash which is stored.
+func newResetToken() (string, string, error) {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	token := strings.ToLower(base32.StdEncoding.EncodeToString(random))
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(token))))
+	return hex.EncodeToString(hash[:])
+}
+
+// checkResetToken returns whether token is the account's reset token.
+func checkResetToken(account *Account, token []byte) bool {
+	if account.resetToken == "" {
+		return false
+	}
+	hash := hashResetToken(string(token))
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(account.resetToken)) == 1
+}
+
+// handleResetLogin logs in an account whose password was reset, with its reset token, and makes them choose a new password.
+func handleResetLogin(world World, c net.Conn, account *Account) bool {
+	ip := remoteIP(c)
+	token, err := readPassword(c, "Your password has been reset. Please enter the reset token you were given.")
+	defer zero(token)
+	if err != nil {
+		return false
+	}
+	if !checkResetToken(account, token) {
+		loginFailed(ip)
+		c.Write([]byte("Invalid reset token.\r\n"))
+		c.Close()
+		return false
+	}
+	loginSucceeded(ip)
+	for {
+		pass, ok, err := readNewPassword(c)
+		if err != nil {
+			return false
+		}
+		if !ok {
+			c.Write([]byte("The passwords you entered do not match.\r\n"))
+			continue
+		}
+		err = setPassword(&world, account, pass)
+		zero(pass)
+		if err != nil {
+			fmt.Println("reset password error: " + err.Error())
+			c.Close()
+			return false
+		}
+		c.Write([]byte("Your password has been changed.\r\n"))
+		return true
+	}
+}
+
+//
+// commands
+//
+
+// password changes the password of the player's account. It reads the passwords from the player's connection, which is safe
+// because commands are run by the player's reader.
+func password(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("password error: player not found " + playerId.String())
+		return
+	}
+	c := player.connection
+	if c == nil {
+		return
+	}
+	account, exists := loadAccountById(world.db, player.account)
+	if !exists {
+		fmt.Println("password error: account not found " + player.account.String())
+		player.Write("Your password could not be changed.")
+		return
+	}
+
+	oldPass, err := readPassword(c, "Please enter your current password.")
+	defer zero(oldPass)
+	if err != nil {
+		return
+	}
+	if ok, err := checkPassword(account, oldPass); err != nil || !ok {
+		loginFailed(remoteIP(c))
+		player.Write("That is not your password.")
+		return
+	}
+	pass, ok, err := readNewPassword(c)
+	if err != nil {
+		return
+	}
+	if !ok {
+		player.Write("The passwords you entered do not match. Your password has not been changed.")
+		return
+	}
+	err = setPassword(world, account, pass)
+	zero(pass)
+	if err != nil {
+		fmt.Println("password error: " + err.Error())
+		player.Write("Your password could not be changed.")
+		return
+	}
+	player.Write("Your password has been changed.")
+}
+
+// resetPassword gives the admin a one-time token, which the account must log in with, and then choose a new password.
+// The account may be given by its login, or the name of any of its characters.
+func resetPassword(args []string, playerId identifier, world *World) {
+	admin, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("resetpassword error: player not found " + playerId.String())
+		return
+	}
+	if len(args) < 1 {
+		admin.Write("Whose password do you want to reset? resetpassword account|character")
+		return
+	}
+	name := strings.ToLower(args[0])
+	account, exists := findAccount(world.db, name)
+	if !exists {
+		admin.Write("There is no account or character by that name.")
+		return
+	}
+	if accountRole(world, account) >= admin.Role() && admin.Role() != roleOwner {
+		admin.Write("You can't reset the password of " + ToProper(account.Login()) + "'s account.")
+		return
+	}
+	token, hash, err := newResetToken()
+	if err != nil {
+		fmt.Println("resetpassword error: " + err.Error())
+		admin.Write("The password could not be reset.")
+		return
+	}
+	account.resetToken = hash
+	saveAccountPassword(world.db, account)
+	admin.Write("The password of " + ToProper(account.Login()) + "'s account has been reset. Its one-time reset token is: " + token)
+}
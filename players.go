@@ -13,11 +13,21 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 	//	 "runtime/debug"
 )
 
 type PlayerItemType int32
 
+// PlayerState is the state of a player's session.
+type PlayerState int32
+
+const (
+	psPlaying  = PlayerState(iota)
+	psLinkDead // the connection closed, and the player is waiting in the world to reconnect
+	psQuitting // the player quit, and is removed when the connection closes
+)
+
 const (
 	piItem = iota
 	piNpc
@@ -32,28 +42,74 @@ const (
 type Player struct {
 	id          identifier
 	name        string
-	passthesalt []byte
-	pass        []byte
+	account     identifier // the account which owns the player
 	connection  net.Conn
 	level       uint
 	health      uint
 	mana        uint
 	Room        identifier
 	Items       map[identifier]PlayerItemType
+	Aliases     map[string]string            // the player's command aliases, by name
+	Channels    map[string]ChannelMembership // whether the player is on chat channels, if it isn't the default. See channels.go
+	Description string                       // what other players see when they look at the player
+	Pose        string                       // what the player is doing, which the room shows until they move. See socials.go
+	Wrap        int                          // the player's wrap width. 0 uses the client's window size, negative disables wrapping.
+	role        Role
+	state       PlayerState
+	linkDead    time.Time // when the player went link-dead
 }
 
-/// @todo change this to write to a channel for a manager, to prevent concurrent access to the connection
+// Write sends the message to the player, followed by their prompt.
+// The connection is a Session, so this never blocks, and may be called from anywhere, including a setter.
 func (p *Player) Write(message string) {
 	if len(message) == 0 {
 		fmt.Println("player.Write called with empty string '" + p.Name() + "'")
 	}
+	if p.connection == nil {
+		return // link-dead
+	}
+	message = WordWrap(message, p.WrapWidth())
 	p.connection.Write([]byte("\r\n" + message + "\r\n" + p.Prompt()))
 }
 
+// WrapWidth returns the width the player's output is wrapped to, or 0 for no wrapping.
+// The player's own setting takes precedence over the window size the client sent.
+func (p *Player) WrapWidth() int {
+	if p.Wrap < 0 {
+		return 0
+	} else if p.Wrap > 0 {
+		return p.Wrap
+	}
+	if t, ok := telnetOf(p.connection); ok {
+		if width, _ := t.WindowSize(); width > 1 {
+			return width - 1 // many terminals add a blank line if a line exactly fills the window
+		}
+	}
+	return defaultWrapWidth
+}
+
 func (p *Player) Id() identifier {
 	return p.id
 }
 
+// Snapshot returns a copy of the player which later changes to them don't touch, for publishing. See Snapshotter.
+func (p *Player) Snapshot() Thing {
+	s := *p
+	s.Items = make(map[identifier]PlayerItemType, len(p.Items))
+	for id, itemType := range p.Items {
+		s.Items[id] = itemType
+	}
+	s.Aliases = make(map[string]string, len(p.Aliases))
+	for name, expansion := range p.Aliases {
+		s.Aliases[name] = expansion
+	}
+	s.Channels = make(map[string]ChannelMembership, len(p.Channels))
+	for name, membership := range p.Channels {
+		s.Channels[name] = membership
+	}
+	return &s
+}
+
 func (p *Player) SetId(newId identifier) {
 	p.id = newId
 }
@@ -62,6 +118,14 @@ func (p *Player) Name() string {
 	return p.name
 }
 
+func (p *Player) Role() Role {
+	return p.role
+}
+
+func (p *Player) LinkDead() bool {
+	return p.state == psLinkDead
+}
+
 func (p *Player) MaxHealth() uint {
 	return 500 * p.level
 }
@@ -240,6 +304,7 @@ func (m PlayerManager) Move(playerId identifier, direction Direction, world *Wor
 		}
 
 		player.Room = newRoom.Id()
+		player.Pose = ""
 		delete(room.Players, player.Id())
 		newRoom.Players[player.Id()] = true
 		player.Write("You move out to the " + direction.String() + ".")
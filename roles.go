@@ -0,0 +1,93 @@
+/*
+roles.go contains the roles which players may have, which control the commands they can use.
+
+Every role can use the commands of the roles below it.
+Builders can build the world, and animate NPCs. Admins can run the server, and grant roles up to builder.
+The owner can do everything, and grant any role. The first player created on an empty database is the owner.
+*/
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type Role int32
+
+const (
+	rolePlayer = Role(iota)
+	roleBuilder
+	roleAdmin
+	roleOwner
+)
+
+func (r Role) String() string {
+	switch r {
+	case rolePlayer:
+		return "player"
+	case roleBuilder:
+		return "builder"
+	case roleAdmin:
+		return "admin"
+	case roleOwner:
+		return "owner"
+	}
+	return "unknown"
+}
+
+func StringToRole(s string) (Role, bool) {
+	switch strings.ToLower(s) {
+	case "player":
+		return rolePlayer, true
+	case "builder":
+		return roleBuilder, true
+	case "admin":
+		return roleAdmin, true
+	case "owner":
+		return roleOwner, true
+	}
+	return rolePlayer, false
+}
+
+// CanGrant returns whether a player with role r may change a player with role from, to role to.
+// Only the owner may grant or revoke admin and owner.
+func (r Role) CanGrant(from Role, to Role) bool {
+	if r == roleOwner {
+		return true
+	}
+	return r >= roleAdmin && from < roleAdmin && to < roleAdmin
+}
+
+// characterCreation serialises creating characters, so two first characters can't both become the owner,
+// and two connections can't create characters with the same name.
+var characterCreation sync.Mutex
+
+// noPlayersExist returns whether no players have ever been created, so the next one is the owner.
+func noPlayersExist(world *World) bool {
+	if ThingManager(*world.players).Count() > 0 {
+		return false
+	}
+	if world.db == nil {
+		return true
+	}
+	var count int
+	if err := world.db.QueryRow(`select count(*) from players;`).Scan(&count); err != nil {
+		return false
+	}
+	return count == 0
+}
+
+// findOwnerAccount returns the account of the character named by -owner, or invalidIdentifier if there's no such character.
+// Only that account's character is made the owner, so nobody can claim the name by creating the character later.
+func findOwnerAccount(world *World) identifier {
+	if world.config.Owner == "" || world.db == nil {
+		return invalidIdentifier
+	}
+	var account identifier
+	if err := world.db.QueryRow(`select account_id from players where name = ?;`, world.config.Owner).Scan(&account); err != nil {
+		fmt.Println("-owner " + world.config.Owner + " is not an existing character, and is ignored")
+		return invalidIdentifier
+	}
+	return account
+}
@@ -12,6 +12,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type Room struct {
@@ -21,6 +23,7 @@ type Room struct {
 	Exits       map[Direction]identifier
 	Players     map[identifier]bool
 	Items       map[identifier]PlayerItemType
+	Extras      map[string]string // descriptions of things in the room which players can look at, by their keywords
 }
 
 func (r *Room) Id() identifier {
@@ -35,6 +38,28 @@ func (r *Room) Name() string {
 	return r.name
 }
 
+// Snapshot returns a copy of the room which later changes to it don't touch, for publishing. See Snapshotter.
+func (r *Room) Snapshot() Thing {
+	s := *r
+	s.Exits = make(map[Direction]identifier, len(r.Exits))
+	for direction, id := range r.Exits {
+		s.Exits[direction] = id
+	}
+	s.Players = make(map[identifier]bool, len(r.Players))
+	for id, present := range r.Players {
+		s.Players[id] = present
+	}
+	s.Items = make(map[identifier]PlayerItemType, len(r.Items))
+	for id, itemType := range r.Items {
+		s.Items[id] = itemType
+	}
+	s.Extras = make(map[string]string, len(r.Extras))
+	for keywords, description := range r.Extras {
+		s.Extras[keywords] = description
+	}
+	return &s
+}
+
 func (r Room) PrintDirections() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(Brown)
@@ -156,6 +181,7 @@ func (r Room) printPlayers(world *World, currentPlayerName string) string {
 		return ""
 	}
 	var players []string
+	var poses []string
 	for playerId, _ := range r.Players {
 		if playerId == currentPlayer.Id() {
 			continue
@@ -164,11 +190,25 @@ func (r Room) printPlayers(world *World, currentPlayerName string) string {
 		if !ok {
 			continue
 		}
+		if player.LinkDead() {
+			players = append(players, player.Name()+" (link-dead)")
+			continue
+		}
+		if player.Pose != "" {
+			poses = append(poses, ToSentence(ToProper(player.Name())+" "+player.Pose)+"\r\n")
+			continue
+		}
 		players = append(players, player.Name())
 
 	}
+	sort.Strings(poses)
+	buffer.WriteString(strings.Join(poses, ""))
 	if len(players) == 0 {
-		return ""
+		if len(poses) == 0 {
+			return ""
+		}
+		buffer.WriteString(Reset)
+		return buffer.String()
 	}
 	if len(players) == 1 {
 		buffer.WriteString(ToProper(players[0]))
@@ -206,6 +246,7 @@ func (r *Room) NewRoom(manager *RoomManager, d Direction, newName string, newDes
 		Exits:       make(map[Direction]identifier),
 		Players:     make(map[identifier]bool),
 		Items:       make(map[identifier]PlayerItemType),
+		Extras:      make(map[string]string),
 	}
 	newRoom.Exits[d.reverse()] = r.id
 	newRoomId := ThingManager(*manager).Add(&newRoom)
@@ -218,15 +259,19 @@ func (r *Room) NewRoom(manager *RoomManager, d Direction, newName string, newDes
 	}
 }
 
-func (r Room) Write(message string, playerManager PlayerManager, originator string) {
+// Write writes the message to the players in the room, except the originators, who are usually told something else.
+func (r Room) Write(message string, playerManager PlayerManager, originators ...string) {
+Players:
 	for pid, _ := range r.Players {
 		player, exists := playerManager.GetById(pid)
 		if !exists {
 			fmt.Println("Room.Write got nonexistent player '" + pid.String() + "'")
 			continue
 		}
-		if player.Name() == originator {
-			continue
+		for _, originator := range originators {
+			if player.Name() == originator {
+				continue Players
+			}
 		}
 		player.Write(message)
 	}
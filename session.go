@@ -0,0 +1,326 @@
+/*
+session.go gives every connection a single writer goroutine, fed by a bounded queue.
+
+Anything may write to a player, from any goroutine, including inside a Thing's setter.
+Writes are queued and return immediately, so messages to a player are never interleaved,
+they arrive in the order they were written, and a stalled client can't block the world.
+
+Telnet codes, such as option negotiation and GMCP, go through the same queue, so they're ordered with the text around them.
+
+When a client stops reading and its queue fills, the overflow policy either drops the
+oldest queued message, or disconnects the client. Control messages, which the connection
+or a waiting Flush depend on, are never dropped.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type OverflowPolicy int
+
+const (
+	overflowDropOldest OverflowPolicy = iota
+	overflowDisconnect
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case overflowDropOldest:
+		return "drop"
+	case overflowDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+func StringToOverflowPolicy(s string) (OverflowPolicy, bool) {
+	switch strings.ToLower(s) {
+	case "drop":
+		return overflowDropOldest, true
+	case "disconnect":
+		return overflowDisconnect, true
+	}
+	return overflowDropOldest, false
+}
+
+const defaultOutputQueue = 256
+
+// sessionWriteTimeout is how long a single write to the client may block before the client is considered dead.
+const sessionWriteTimeout = 30 * time.Second
+
+// sessionWrite is a message queued for the client. If done isn't nil, it's closed once everything before it is written.
+type sessionWrite struct {
+	data    []byte
+	write   func() error // if not nil, called by the writer instead of writing data, e.g. for telnet codes; see TelnetConn.queue
+	control bool         // whether the message mustn't be dropped, e.g. telnet negotiation
+	done    chan bool
+}
+
+// droppable returns whether the message may be dropped when the queue is full.
+func (m sessionWrite) droppable() bool {
+	return !m.control && m.done == nil
+}
+
+// Session wraps a connection, telnet or web, queueing its writes for a single writer goroutine.
+type Session struct {
+	net.Conn
+	queue     []sessionWrite
+	queueSize int
+	policy    OverflowPolicy
+	mutex     sync.Mutex // guards closed, player, and queue
+	wake      *sync.Cond // signalled when a message is queued, or the session is closed
+	closed    bool
+	closeOnce sync.Once
+	dropping  bool       // whether messages have been dropped, so it's only logged once
+	player    identifier // the logged in player, or invalidIdentifier
+	ip        string
+
+	// input limits; see input.go
+	loginTimeout time.Duration
+	idleTimeout  time.Duration
+	maxLine      int
+	commands     tokenBucket
+	throttled    bool
+}
+
+// sessions is every open session, so they can be found without a player, e.g. to broadcast or copyover.
+var sessions = struct {
+	sync.Mutex
+	all   map[*Session]bool
+	perIP map[string]int
+}{all: make(map[*Session]bool), perIP: make(map[string]int)}
+
+// sessionCounts returns the number of open sessions, and the number from the given IP.
+func sessionCounts(ip string) (int, int) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return len(sessions.all), sessions.perIP[ip]
+}
+
+// Sessions returns every open session.
+func Sessions() []*Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	all := make([]*Session, 0, len(sessions.all))
+	for s := range sessions.all {
+		all = append(all, s)
+	}
+	return all
+}
+
+func NewSession(c net.Conn, config Config) *Session {
+	queueSize := config.OutputQueue
+	if queueSize < 1 {
+		queueSize = defaultOutputQueue
+	}
+	s := &Session{
+		Conn:      c,
+		queueSize: queueSize,
+		policy:    config.Overflow,
+		player:    invalidIdentifier,
+		ip:        remoteIP(c),
+
+		loginTimeout: config.LoginTimeout,
+		idleTimeout:  config.IdleTimeout,
+		maxLine:      config.MaxLineLength,
+		commands:     newTokenBucket(config.CommandRate, config.CommandBurst),
+	}
+	sessions.Lock()
+	sessions.all[s] = true
+	sessions.perIP[s.ip]++
+	sessions.Unlock()
+	s.wake = sync.NewCond(&s.mutex)
+	if t, ok := c.(*TelnetConn); ok {
+		t.setQueue(s.queueControl)
+	}
+	go s.writer()
+	return s
+}
+
+// sessionOf returns the Session of the given connection, if it is one.
+func sessionOf(c net.Conn) (*Session, bool) {
+	s, ok := c.(*Session)
+	return s, ok
+}
+
+// Unwrap returns the connection the session wraps.
+func (s *Session) Unwrap() net.Conn {
+	return s.Conn
+}
+
+// IP returns the address the client connected from.
+func (s *Session) IP() string {
+	return s.ip
+}
+
+// SetPlayer sets the player logged in on the session.
+func (s *Session) SetPlayer(id identifier) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.player = id
+}
+
+// Player returns the player logged in on the session, or invalidIdentifier.
+func (s *Session) Player() identifier {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.player
+}
+
+// next waits for the next queued message, and returns it, or false if the session is closed and the queue is empty.
+func (s *Session) next() (sessionWrite, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.wake.Wait()
+	}
+	if len(s.queue) == 0 {
+		return sessionWrite{}, false
+	}
+	message := s.queue[0]
+	s.queue[0] = sessionWrite{}
+	s.queue = s.queue[1:]
+	return message, true
+}
+
+// writer writes queued messages to the client until the session is closed and the queue is empty.
+func (s *Session) writer() {
+	failed := false
+	for {
+		message, ok := s.next()
+		if !ok {
+			break
+		}
+		if message.done != nil {
+			close(message.done)
+			continue
+		}
+		if failed {
+			continue // drain, so Flush never waits on a dead client
+		}
+		s.Conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		var err error
+		if message.write != nil {
+			err = message.write()
+		} else {
+			_, err = s.Conn.Write(message.data)
+		}
+		if err != nil {
+			fmt.Println("session write error: " + err.Error())
+			failed = true
+			s.closeConn()
+		}
+	}
+	s.closeConn()
+}
+
+// enqueue queues the message, applying the overflow policy if the queue is full. It never blocks.
+func (s *Session) enqueue(message sessionWrite) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errors.New("session closed")
+	}
+	if len(s.queue) >= s.queueSize && !s.dropOldest() {
+		fmt.Println("session output queue full, disconnecting " + s.RemoteAddr().String())
+		s.closed = true
+		s.wake.Signal()
+		s.abort()
+		return errors.New("session output queue full")
+	}
+	s.queue = append(s.queue, message)
+	s.wake.Signal()
+	return nil
+}
+
+// dropOldest drops the oldest droppable message from the full queue, if the overflow policy allows.
+// It returns false if nothing was dropped, and the client should be disconnected. s.mutex must be held.
+func (s *Session) dropOldest() bool {
+	if s.policy == overflowDisconnect {
+		return false
+	}
+	for i, message := range s.queue {
+		if !message.droppable() {
+			continue
+		}
+		if !s.dropping {
+			fmt.Println("session output queue full, dropping messages to " + s.RemoteAddr().String())
+			s.dropping = true
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		return true
+	}
+	return false // the queue is all control messages; the client isn't reading, but keeps sending commands
+}
+
+// queueControl queues a write of protocol codes, such as telnet negotiation, in order with the text around it. See TelnetConn.queue.
+func (s *Session) queueControl(write func() error, control bool) error {
+	return s.enqueue(sessionWrite{write: write, control: control})
+}
+
+// Write queues the message for the client. It never blocks.
+func (s *Session) Write(p []byte) (int, error) {
+	message := make([]byte, len(p))
+	copy(message, p)
+	if err := s.enqueue(sessionWrite{data: message}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush waits until everything queued so far is written, or the timeout passes. It returns whether everything was written.
+func (s *Session) Flush(timeout time.Duration) bool {
+	done := make(chan bool)
+	if err := s.enqueue(sessionWrite{done: done}); err != nil {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close closes the session after the queued messages are written.
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.wake.Signal()
+	return nil
+}
+
+// abort closes the client's connection without finishing the telnet or websocket stream, which would wait for
+// the writer. If the writer is stuck writing to a client which stopped reading, this makes it fail at once.
+func (s *Session) abort() {
+	conn := s.Conn
+	switch c := conn.(type) {
+	case *TelnetConn:
+		conn = c.Conn
+	case *WebSocketConn:
+		conn = c.Conn
+	}
+	conn.Close()
+}
+
+func (s *Session) closeConn() {
+	s.closeOnce.Do(func() {
+		s.Conn.Close()
+		sessions.Lock()
+		delete(sessions.all, s)
+		if sessions.perIP[s.ip]--; sessions.perIP[s.ip] <= 0 {
+			delete(sessions.perIP, s.ip)
+		}
+		sessions.Unlock()
+	})
+}
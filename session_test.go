@@ -0,0 +1,152 @@
+/*
+session_test.go tests the session output queue: ordering, and the overflow policies.
+*/
+package main
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingConn is a net.Conn whose first Write stalls until released, like a client which stops reading.
+type stallingConn struct {
+	net.Conn
+	stalled  chan bool
+	release  chan bool
+	mutex    sync.Mutex
+	writes   int
+	written  bytes.Buffer
+	isClosed bool
+}
+
+func newStallingConn() *stallingConn {
+	return &stallingConn{stalled: make(chan bool), release: make(chan bool)}
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	c.writes++
+	first := c.writes == 1
+	c.mutex.Unlock()
+	if first {
+		c.stalled <- true
+		<-c.release
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.written.Write(p)
+}
+
+func (c *stallingConn) Written() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func (c *stallingConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.isClosed = true
+	return nil
+}
+
+func (c *stallingConn) closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.isClosed
+}
+
+func (c *stallingConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+}
+
+func (c *stallingConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// stalledSession returns a telnet session whose writer is stuck writing "a", and which queues size messages.
+func stalledSession(policy OverflowPolicy, size int) (*Session, *TelnetConn, *stallingConn) {
+	c := newStallingConn()
+	t := NewTelnetConn(c)
+	s := NewSession(t, Config{OutputQueue: size, Overflow: policy})
+	s.Write([]byte("a"))
+	<-c.stalled
+	return s, t, c
+}
+
+func TestSessionDropOldestKeepsControl(t *testing.T) {
+	s, tc, c := stalledSession(overflowDropOldest, 3)
+	tc.sendCommand(WILL, optEcho)
+	s.Write([]byte("b"))
+	s.Write([]byte("c"))
+	s.Write([]byte("d"))
+	done := make(chan bool)
+	if err := s.enqueue(sessionWrite{done: done}); err != nil {
+		t.Fatalf("queueing a flush returned %v", err)
+	}
+	s.Write([]byte("e"))
+	tc.Subnegotiate(optGMCP, []byte("x"))
+	close(c.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("the flush was dropped")
+	}
+	s.Close()
+	want := []byte{'a', IAC, WILL, optEcho, IAC, SB, optGMCP, 'x', IAC, SE}
+	for deadline := time.Now().Add(time.Second); !bytes.Equal(c.Written(), want) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+}
+
+func TestSessionTelnetCodesInOrder(t *testing.T) {
+	s, tc, c := stalledSession(overflowDisconnect, 4)
+	s.Write([]byte("b"))
+	tc.Subnegotiate(optGMCP, []byte("x"))
+	s.Write([]byte("c"))
+	close(c.release)
+	if !s.Flush(time.Second) {
+		t.Fatalf("flush timed out")
+	}
+	want := []byte{'a', 'b', IAC, SB, optGMCP, 'x', IAC, SE, 'c'}
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Errorf("wrote %v, want %v", got, want)
+	}
+	s.Close()
+}
+
+func TestSessionFullOfControlDisconnects(t *testing.T) {
+	s, tc, c := stalledSession(overflowDropOldest, 3)
+	defer close(c.release)
+	tc.sendCommand(WILL, optEcho)
+	tc.sendCommand(DO, optNAWS)
+	tc.sendCommand(WILL, optGMCP)
+	if _, err := s.Write([]byte("b")); err == nil {
+		t.Errorf("writing to a queue full of control messages succeeded, want a disconnect")
+	}
+	if !c.closed() {
+		t.Errorf("the connection wasn't closed")
+	}
+}
+
+func TestSessionDisconnectPolicy(t *testing.T) {
+	s, _, c := stalledSession(overflowDisconnect, 3)
+	defer close(c.release)
+	for _, text := range []string{"b", "c", "d"} {
+		if _, err := s.Write([]byte(text)); err != nil {
+			t.Fatalf("write %q returned %v", text, err)
+		}
+	}
+	if _, err := s.Write([]byte("e")); err == nil {
+		t.Errorf("writing to a full queue succeeded, want a disconnect")
+	}
+	if _, err := s.Write([]byte("f")); err == nil {
+		t.Errorf("writing to a disconnected session succeeded")
+	}
+}
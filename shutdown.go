@@ -0,0 +1,193 @@
+/*
+shutdown.go stops the server without losing data.
+
+A shutdown is started by SIGINT or SIGTERM, or the shutdown command. Players are warned with a countdown,
+then the listeners are closed, players are disconnected, every saver and the commit manager are drained,
+and the database is closed.
+
+The exit status is 0 if every database write succeeded, and 1 if any failed.
+*/
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownDelay = 10 * time.Second
+
+// sessionDrainTimeout is how long to wait for disconnected players' queued output to be written.
+const sessionDrainTimeout = 5 * time.Second
+
+// listeners is every open listener, so they can be closed on shutdown.
+var listeners = struct {
+	sync.Mutex
+	all    []net.Listener
+	closed bool
+}{}
+
+// addListener registers the listener to be closed on shutdown.
+func addListener(ln net.Listener) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.all = append(listeners.all, ln)
+}
+
+func closeListeners() {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.closed = true
+	for _, ln := range listeners.all {
+		ln.Close()
+	}
+	listeners.all = nil
+}
+
+// listenersClosed returns whether the listeners were closed, so accept loops know to stop.
+func listenersClosed() bool {
+	listeners.Lock()
+	defer listeners.Unlock()
+	return listeners.closed
+}
+
+// dbWriteErrors counts failed database writes, so shutdown can report whether everything was saved.
+var dbWriteErrors int64
+
+func dbWriteError(err error) {
+	atomic.AddInt64(&dbWriteErrors, 1)
+	fmt.Print("db write err: ")
+	fmt.Println(err)
+}
+
+var shutdownState = struct {
+	sync.Mutex
+	pending bool
+	cancel  chan bool
+	now     chan bool
+}{}
+
+// startShutdown starts a countdown, after which the server shuts down. It returns false if a shutdown is already pending.
+func startShutdown(world *World, delay time.Duration) bool {
+	shutdownState.Lock()
+	defer shutdownState.Unlock()
+	if shutdownState.pending {
+		return false
+	}
+	shutdownState.pending = true
+	shutdownState.cancel = make(chan bool, 1)
+	shutdownState.now = make(chan bool, 1)
+	go runShutdown(world, delay, shutdownState.cancel, shutdownState.now)
+	return true
+}
+
+// cancelShutdown stops a pending shutdown. It returns false if there wasn't one.
+func cancelShutdown() bool {
+	shutdownState.Lock()
+	defer shutdownState.Unlock()
+	if !shutdownState.pending {
+		return false
+	}
+	shutdownState.pending = false
+	shutdownState.cancel <- true
+	return true
+}
+
+// hurryShutdown ends a pending shutdown's countdown immediately.
+func hurryShutdown() {
+	shutdownState.Lock()
+	defer shutdownState.Unlock()
+	if !shutdownState.pending {
+		return
+	}
+	select {
+	case shutdownState.now <- true:
+	default:
+	}
+}
+
+func broadcast(message string) {
+	for _, s := range Sessions() {
+		s.Write([]byte("\r\n" + message + "\r\n"))
+	}
+}
+
+func shutdownWarning(remaining time.Duration) string {
+	seconds := int((remaining + time.Second/2) / time.Second)
+	if seconds == 1 {
+		return Red + "The world will end in 1 second." + Reset
+	}
+	return Red + "The world will end in " + strconv.Itoa(seconds) + " seconds." + Reset
+}
+
+func runShutdown(world *World, delay time.Duration, cancel chan bool, now chan bool) {
+	fmt.Println("shutdown in " + delay.String())
+	end := time.Now().Add(delay)
+	if delay > 0 {
+		broadcast(shutdownWarning(delay))
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for time.Now().Before(end) {
+		select {
+		case <-cancel:
+			fmt.Println("shutdown cancelled")
+			broadcast(Green + "The world will not end after all." + Reset)
+			return
+		case <-now:
+			end = time.Now()
+		case <-ticker.C:
+			switch seconds := int((time.Until(end) + time.Second/2) / time.Second); seconds {
+			case 60, 30, 10, 5, 4, 3, 2, 1:
+				broadcast(shutdownWarning(time.Until(end)))
+			}
+		}
+	}
+	shutdown(world)
+}
+
+// shutdown disconnects everyone, saves everything, and exits.
+func shutdown(world *World) {
+	fmt.Println("shutting down")
+	closeListeners()
+
+	for _, s := range Sessions() {
+		s.Write([]byte("\r\nThe world fades away. Goodbye.\r\n"))
+		s.Close()
+	}
+	for deadline := time.Now().Add(sessionDrainTimeout); len(Sessions()) > 0 && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	flushDb(world)
+	if world.db != nil {
+		if err := world.db.Close(); err != nil {
+			dbWriteError(err)
+		}
+	}
+
+	if errors := atomic.LoadInt64(&dbWriteErrors); errors > 0 {
+		fmt.Println("shutdown complete, but " + strconv.FormatInt(errors, 10) + " database writes failed")
+		os.Exit(1)
+	}
+	fmt.Println("shutdown complete, everything was saved")
+	os.Exit(0)
+}
+
+// handleSignals shuts down on SIGINT or SIGTERM. A second signal skips the countdown.
+func handleSignals(world *World, delay time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	for sig := range signals {
+		fmt.Println("received " + sig.String())
+		if !startShutdown(world, delay) {
+			hurryShutdown()
+		}
+	}
+}
@@ -0,0 +1,375 @@
+/*
+socials.go contains emotes, poses and socials.
+
+emote describes an action, e.g. 'emote waves' shows "Bob waves." to the room.
+pose describes what the player is doing until they move, which the room shows when looked at.
+
+Socials are canned emotes, such as smile and bow, which players type like commands, with or without a target.
+They're stored in the socials table, which is filled with defaultSocials when it's empty, and builders can edit them with editsocial.
+In their messages, $n is the player doing the social, and $N is the target.
+Only names are substituted: players have no pronouns, so messages which need one use they, them and themselves.
+A social's name can't be the start of a command's name, because socials are matched before commands are matched by prefix,
+so a social called 'lo' would stop 'lo' meaning look.
+*/
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Social is a canned emote. Each message is what someone sees, in one case.
+type Social struct {
+	Name        string
+	Actor       string // what the player sees with no target, e.g. "You smile."
+	Room        string // what everyone else sees with no target, e.g. "$n smiles."
+	ActorTarget string // what the player sees with a target, e.g. "You smile at $N."
+	Target      string // what the target sees, e.g. "$n smiles at you."
+	RoomTarget  string // what everyone else sees with a target, e.g. "$n smiles at $N."
+	ActorSelf   string // what the player sees targeting themselves, e.g. "You smile to yourself."
+	RoomSelf    string // what everyone else sees when the player targets themselves
+}
+
+// socialFields are the messages of a social, by the name builders edit them with.
+var socialFields = map[string]func(s *Social) *string{
+	"actor":       func(s *Social) *string { return &s.Actor },
+	"room":        func(s *Social) *string { return &s.Room },
+	"actortarget": func(s *Social) *string { return &s.ActorTarget },
+	"target":      func(s *Social) *string { return &s.Target },
+	"roomtarget":  func(s *Social) *string { return &s.RoomTarget },
+	"actorself":   func(s *Social) *string { return &s.ActorSelf },
+	"roomself":    func(s *Social) *string { return &s.RoomSelf },
+}
+
+var socialFieldNames = []string{"actor", "room", "actortarget", "target", "roomtarget", "actorself", "roomself"}
+
+var defaultSocials = []Social{
+	{"smile", "You smile.", "$n smiles.", "You smile at $N.", "$n smiles at you.", "$n smiles at $N.", "You smile to yourself.", "$n smiles to themselves."},
+	{"bow", "You bow deeply.", "$n bows deeply.", "You bow before $N.", "$n bows before you.", "$n bows before $N.", "You bow to your reflection.", "$n bows to nobody in particular."},
+	{"wave", "You wave.", "$n waves.", "You wave at $N.", "$n waves at you.", "$n waves at $N.", "You wave at yourself.", "$n waves at themselves."},
+	{"nod", "You nod.", "$n nods.", "You nod at $N.", "$n nods at you.", "$n nods at $N.", "You nod to yourself.", "$n nods to themselves."},
+	{"laugh", "You laugh.", "$n laughs.", "You laugh at $N.", "$n laughs at you.", "$n laughs at $N.", "You laugh at yourself.", "$n laughs at themselves."},
+	{"shrug", "You shrug.", "$n shrugs.", "You shrug at $N.", "$n shrugs at you.", "$n shrugs at $N.", "", ""},
+	{"grin", "You grin.", "$n grins.", "You grin at $N.", "$n grins at you.", "$n grins at $N.", "", ""},
+	{"hug", "Hug whom?", "", "You hug $N.", "$n hugs you.", "$n hugs $N.", "You hug yourself.", "$n hugs themselves."},
+}
+
+var socials = map[string]Social{}
+var socialsMutex sync.RWMutex
+
+const validSocialRegex = "^[a-z]+$"
+
+func getSocial(name string) (Social, bool) {
+	socialsMutex.RLock()
+	defer socialsMutex.RUnlock()
+	social, ok := socials[name]
+	return social, ok
+}
+
+func socialNames() []string {
+	socialsMutex.RLock()
+	defer socialsMutex.RUnlock()
+	names := make([]string, 0, len(socials))
+	for name := range socials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// socialCommand returns a command which does the social with the name, for the parser.
+func socialCommand(name string) *Command {
+	if _, ok := getSocial(name); !ok {
+		return nil
+	}
+	return &Command{
+		Name:  name,
+		Usage: "[person]",
+		Func: func(args []string, playerId identifier, world *World) {
+			doSocial(name, args, playerId, world)
+		},
+	}
+}
+
+// isCommandPrefix returns whether the name is the start of a command's name, or an alias of a command,
+// so a social or channel with the name would hide the command from players typing it.
+func isCommandPrefix(name string) bool {
+	for _, command := range commands {
+		if strings.HasPrefix(command.Name, name) {
+			return true
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderSocial substitutes the names into a social's message. Nothing else is substituted, such as pronouns.
+func renderSocial(message string, actor string, target string) string {
+	return ToProper(strings.NewReplacer("$n", ToProper(actor), "$N", ToProper(target)).Replace(message))
+}
+
+func loadSocials(db *sql.DB) {
+	rows, err := db.Query(`select name, actor, room, actor_target, target, room_target, actor_self, room_self from socials;`)
+	if err != nil {
+		fmt.Print("dberr loadSocials ")
+		fmt.Println(err)
+		return
+	}
+	loaded := map[string]Social{}
+	for rows.Next() {
+		var s Social
+		rows.Scan(&s.Name, &s.Actor, &s.Room, &s.ActorTarget, &s.Target, &s.RoomTarget, &s.ActorSelf, &s.RoomSelf)
+		loaded[s.Name] = s
+	}
+	rows.Close()
+	if len(loaded) == 0 {
+		for _, s := range defaultSocials {
+			loaded[s.Name] = s
+			saveSocial(db, s)
+		}
+	}
+	socialsMutex.Lock()
+	socials = loaded
+	socialsMutex.Unlock()
+}
+
+func saveSocial(db *sql.DB, s Social) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`insert or replace into socials (name, actor, room, actor_target, target, room_target, actor_self, room_self) values (?,?,?,?,?,?,?,?);`,
+		s.Name, s.Actor, s.Room, s.ActorTarget, s.Target, s.RoomTarget, s.ActorSelf, s.RoomSelf); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+func deleteSocial(db *sql.DB, name string) {
+	if db == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		dbWriteError(err)
+		return
+	}
+	if _, err := tx.Exec(`delete from socials where name = ?;`, name); err != nil {
+		dbWriteError(err)
+	}
+	doCommit <- tx
+}
+
+// findNpcInRoom returns the NPC in the room which the target means.
+func findNpcInRoom(target Target, room *Room, world *World) (*Npc, bool) {
+	n := 0
+	for _, id := range roomNpcs(room) {
+		npc, exists := world.npcs.GetById(id)
+		if !exists || !target.Matches(npc.Keywords()) {
+			continue
+		}
+		n++
+		if n == target.Ordinal {
+			return npc, true
+		}
+	}
+	return nil, false
+}
+
+// doSocial does the social, at the player or NPC in the room which args mean, if any.
+func doSocial(name string, args []string, playerId identifier, world *World) {
+	social, ok := getSocial(name)
+	if !ok {
+		tryPlayerWrite(playerId, world.players, commandRejectMessage, "doSocial called with invalid player")
+		return
+	}
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("doSocial called with invalid player " + playerId.String())
+		return
+	}
+	room, exists := world.rooms.GetById(player.Room)
+	if !exists {
+		fmt.Println("doSocial called with player with invalid room " + playerId.String())
+		return
+	}
+	if len(args) == 0 {
+		if social.Actor == "" {
+			player.Write("You can't do that.")
+			return
+		}
+		player.Write(renderSocial(social.Actor, player.Name(), ""))
+		if social.Room != "" {
+			room.Write(renderSocial(social.Room, player.Name(), ""), *world.players, player.Name())
+		}
+		return
+	}
+	target, ok := ParseTarget(strings.Join(args, " "))
+	if !ok || target.All || target.Id != invalidIdentifier {
+		player.Write("You don't see them here.")
+		return
+	}
+	if other, ok := findPlayerInRoom(target, player, room, world); ok {
+		if other.Id() == player.Id() {
+			if social.ActorSelf == "" {
+				player.Write("You can't do that to yourself.")
+				return
+			}
+			player.Write(renderSocial(social.ActorSelf, player.Name(), player.Name()))
+			if social.RoomSelf != "" {
+				room.Write(renderSocial(social.RoomSelf, player.Name(), player.Name()), *world.players, player.Name())
+			}
+			return
+		}
+		if social.ActorTarget == "" {
+			player.Write("You can't do that to someone.")
+			return
+		}
+		player.Write(renderSocial(social.ActorTarget, player.Name(), other.Name()))
+		if social.Target != "" {
+			other.Write(renderSocial(social.Target, player.Name(), other.Name()))
+		}
+		if social.RoomTarget != "" {
+			room.Write(renderSocial(social.RoomTarget, player.Name(), other.Name()), *world.players, player.Name(), other.Name())
+		}
+		return
+	}
+	if npc, ok := findNpcInRoom(target, room, world); ok {
+		if social.ActorTarget == "" {
+			player.Write("You can't do that to someone.")
+			return
+		}
+		player.Write(renderSocial(social.ActorTarget, player.Name(), npc.Brief))
+		if social.RoomTarget != "" {
+			room.Write(renderSocial(social.RoomTarget, player.Name(), npc.Brief), *world.players, player.Name())
+		}
+		return
+	}
+	player.Write("You don't see them here.")
+}
+
+// emote shows the room the player doing something, e.g. 'emote waves' shows "Bob waves.".
+func emote(args []string, playerId identifier, world *World) {
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		fmt.Println("emote called with invalid player " + playerId.String())
+		return
+	}
+	room, exists := world.rooms.GetById(player.Room)
+	if !exists {
+		fmt.Println("emote called with player with invalid room " + playerId.String())
+		return
+	}
+	message := ToSentence(ToProper(player.Name()) + " " + strings.Join(args, " "))
+	player.Write(message)
+	room.Write(message, *world.players, player.Name())
+}
+
+// pose sets what the room shows the player doing, until they move, e.g. 'pose is sitting by the fire'.
+// With no pose, the player's pose is cleared.
+func pose(args []string, playerId identifier, world *World) {
+	text := strings.Join(args, " ")
+	var message string
+	world.players.ChangeById(playerId, func(p *Player) {
+		p.Pose = text
+		if text == "" {
+			p.Write("You are no longer posing.")
+			return
+		}
+		message = ToSentence(ToProper(p.Name()) + " " + text)
+		p.Write(message)
+	})
+	if message == "" {
+		return
+	}
+	player, exists := world.players.GetById(playerId)
+	if !exists {
+		return
+	}
+	if room, exists := world.rooms.GetById(player.Room); exists {
+		room.Write(message, *world.players, player.Name())
+	}
+}
+
+// listSocials lists the socials players can do.
+func listSocials(args []string, playerId identifier, world *World) {
+	names := socialNames()
+	if len(names) == 0 {
+		tryPlayerWrite(playerId, world.players, "There are no socials.", "socials called with invalid player")
+		return
+	}
+	tryPlayerWrite(playerId, world.players, "Socials: "+strings.Join(names, " "), "socials called with invalid player")
+}
+
+// editSocial creates or edits a social, e.g. 'editsocial smile roomtarget $n smiles warmly at $N.'.
+// With just a name, it shows the social. With no message, the message is removed.
+// Messages can only use the names $n and $N, not pronouns; see renderSocial.
+func editSocial(args []string, playerId identifier, world *World) {
+	name := strings.ToLower(args[0])
+	if len(args) == 1 {
+		social, ok := getSocial(name)
+		if !ok {
+			tryPlayerWrite(playerId, world.players, "There is no social '"+name+"'.", "editsocial called with invalid player")
+			return
+		}
+		s := "Social '" + name + "':"
+		for _, field := range socialFieldNames {
+			s += "\r\n" + fmt.Sprintf("%-12s", field) + *socialFields[field](&social)
+		}
+		tryPlayerWrite(playerId, world.players, s, "editsocial called with invalid player")
+		return
+	}
+	if valid, err := regexp.MatchString(validSocialRegex, name); err != nil || !valid {
+		tryPlayerWrite(playerId, world.players, "Social names can only contain letters.", "editsocial called with invalid player")
+		return
+	}
+	if _, isChannel := getChannel(name, roleOwner); isChannel || isCommandPrefix(name) {
+		tryPlayerWrite(playerId, world.players, "'"+name+"' is the start of a command, so it can't be a social.", "editsocial called with invalid player")
+		return
+	}
+	field, ok := socialFields[strings.ToLower(args[1])]
+	if !ok {
+		tryPlayerWrite(playerId, world.players, "Socials have the messages: "+strings.Join(socialFieldNames, ", "), "editsocial called with invalid player")
+		return
+	}
+	socialsMutex.Lock()
+	social, exists := socials[name]
+	if !exists {
+		social = Social{Name: name}
+	}
+	*field(&social) = strings.Join(args[2:], " ")
+	socials[name] = social
+	socialsMutex.Unlock()
+	saveSocial(world.db, social)
+	if !exists {
+		tryPlayerWrite(playerId, world.players, "You create the social '"+name+"'.", "editsocial called with invalid player")
+		return
+	}
+	tryPlayerWrite(playerId, world.players, "You change the social '"+name+"'.", "editsocial called with invalid player")
+}
+
+func removeSocial(args []string, playerId identifier, world *World) {
+	name := strings.ToLower(args[0])
+	socialsMutex.Lock()
+	_, exists := socials[name]
+	delete(socials, name)
+	socialsMutex.Unlock()
+	if !exists {
+		tryPlayerWrite(playerId, world.players, "There is no social '"+name+"'.", "removesocial called with invalid player")
+		return
+	}
+	deleteSocial(world.db, name)
+	tryPlayerWrite(playerId, world.players, "You remove the social '"+name+"'.", "removesocial called with invalid player")
+}
@@ -0,0 +1,47 @@
+/*
+socials_test.go tests which names socials may have, and substituting names into their messages.
+*/
+package main
+
+import (
+	"testing"
+)
+
+func TestIsCommandPrefix(t *testing.T) {
+	initCommands()
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"look", true},
+		{"lo", true},
+		{"l", true},
+		{"n", true},
+		{"'", true},
+		{"looking", false},
+		{"smile", false},
+	}
+	for _, test := range tests {
+		if got := isCommandPrefix(test.name); got != test.want {
+			t.Errorf("isCommandPrefix(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDefaultSocialsDontHideCommands(t *testing.T) {
+	initCommands()
+	for _, social := range defaultSocials {
+		if isCommandPrefix(social.Name) {
+			t.Errorf("the default social %q hides a command", social.Name)
+		}
+	}
+}
+
+func TestRenderSocial(t *testing.T) {
+	if got, want := renderSocial("$n smiles at $N.", "bob", "alice"), "Bob smiles at Alice."; got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+	if got, want := renderSocial("$n hugs themselves.", "bob", ""), "Bob hugs themselves."; got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+}
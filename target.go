@@ -0,0 +1,205 @@
+/*
+target.go resolves what players type to refer to items and NPCs, for commands such as get, drop and look.
+
+A target is one or more keywords, which match a thing if each is the start of one of its keywords,
+so "rusty sword" and "rus sw" both match an item with the keywords "rusty iron sword".
+"2.sword" is the second thing matching "sword", "all" is everything, and "all.coin" is everything matching "coin".
+A number is a thing's id, which builders use.
+
+Things are searched in the player's inventory, the room, or both, in that order, and in the order they were created,
+so ordinals are stable.
+*/
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Target is what a player typed to refer to things.
+type Target struct {
+	All      bool
+	Ordinal  int        // which of the matches is meant, from 1
+	Id       identifier // invalidIdentifier unless an id was given
+	Keywords []string
+}
+
+// ParseTarget parses a target. It returns false if s is empty, or the ordinal is invalid.
+func ParseTarget(s string) (Target, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	t := Target{Ordinal: 1, Id: invalidIdentifier}
+	if s == "" {
+		return t, false
+	}
+	if id, err := strconv.Atoi(s); err == nil {
+		t.Id = identifier(id)
+		return t, true
+	}
+	if s == "all" {
+		t.All = true
+		return t, true
+	}
+	if i := strings.Index(s, "."); i > 0 {
+		prefix := s[:i]
+		if prefix == "all" {
+			t.All = true
+			s = s[i+1:]
+		} else if n, err := strconv.Atoi(prefix); err == nil {
+			if n < 1 {
+				return t, false
+			}
+			t.Ordinal = n
+			s = s[i+1:]
+		}
+	}
+	t.Keywords = strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '.' })
+	if len(t.Keywords) == 0 && !t.All {
+		return t, false
+	}
+	return t, true
+}
+
+// Matches returns whether a thing with the keywords matches the target's keywords.
+func (t Target) Matches(keywords []string) bool {
+	for _, want := range t.Keywords {
+		found := false
+		for _, keyword := range keywords {
+			if strings.HasPrefix(keyword, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// TargetScope is where a target is searched for.
+type TargetScope int32
+
+const (
+	tsInventory = TargetScope(iota)
+	tsRoom
+	tsInventoryAndRoom
+)
+
+// NotFound is the message for a target which matched nothing in the scope.
+func (s TargetScope) NotFound() string {
+	if s == tsInventory {
+		return "You aren't carrying that."
+	}
+	return "You don't see that here."
+}
+
+// things returns the things in the scope, in search order.
+func (s TargetScope) things(player *Player, room *Room) []map[identifier]PlayerItemType {
+	switch s {
+	case tsInventory:
+		return []map[identifier]PlayerItemType{player.Items}
+	case tsRoom:
+		return []map[identifier]PlayerItemType{room.Items}
+	}
+	return []map[identifier]PlayerItemType{player.Items, room.Items}
+}
+
+func sortedIds(things map[identifier]PlayerItemType) []identifier {
+	ids := make([]identifier, 0, len(things))
+	for id := range things {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// keywords returns the keywords of the item or NPC in got. Things' names are always keywords.
+func keywords(id identifier, got Got) ([]string, bool) {
+	if item, ok := got.items[id]; ok {
+		return item.Keywords(), true
+	}
+	if npc, ok := got.npcs[id]; ok {
+		return npc.Keywords(), true
+	}
+	return nil, false
+}
+
+// ResolveTarget returns the things in the scope which the target means: all the matches for an 'all' target,
+// else the one match with the target's ordinal. The things must be in got.
+func ResolveTarget(t Target, scope TargetScope, player *Player, room *Room, got Got) []identifier {
+	var ids []identifier
+	for _, things := range scope.things(player, room) {
+		ids = append(ids, sortedIds(things)...)
+	}
+	return resolveAmong(t, ids, got)
+}
+
+// resolveAmong returns the things the target means, searching the ids in order. The things must be in got.
+func resolveAmong(t Target, ids []identifier, got Got) []identifier {
+	var matches []identifier
+	n := 0
+	for _, id := range ids {
+		if t.Id != invalidIdentifier {
+			if id == t.Id {
+				return []identifier{id}
+			}
+			continue
+		}
+		thingKeywords, ok := keywords(id, got)
+		if !ok || !t.Matches(thingKeywords) {
+			continue
+		}
+		if t.All {
+			matches = append(matches, id)
+			continue
+		}
+		n++
+		if n == t.Ordinal {
+			return []identifier{id}
+		}
+	}
+	return matches
+}
+
+// doWithTarget gets the player, their room, and the things in the scope, resolves the target among them,
+// and calls act with the things it means, which may be none. act may change anything in got.
+func doWithTarget(world *World, playerId identifier, scope TargetScope, t Target, act func(player *Player, room *Room, targets []identifier, got Got)) error {
+	getPlayer := func(got Got) (*ToGet, error) {
+		return PlayerGet(playerId), nil
+	}
+	getRoom := func(got Got) (*ToGet, error) {
+		player, ok := got.players[playerId]
+		if !ok {
+			return nil, fmt.Errorf("Error resolving target for player %v: not returned from manager!", playerId)
+		}
+		return RoomGet(player.Room), nil
+	}
+	getThings := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room, ok := got.rooms[player.Room]
+		if !ok {
+			return nil, fmt.Errorf("Error resolving target for player %v: room %v not returned from manager!", playerId, player.Room)
+		}
+		toGet := &ToGet{}
+		for _, things := range scope.things(player, room) {
+			for id, itemType := range things {
+				switch itemType {
+				case piItem:
+					toGet.items = append(toGet.items, id)
+				case piNpc:
+					toGet.npcs = append(toGet.npcs, id)
+				}
+			}
+		}
+		return toGet, nil
+	}
+	resolve := func(got Got) (*ToGet, error) {
+		player := got.players[playerId]
+		room := got.rooms[player.Room]
+		act(player, room, ResolveTarget(t, scope, player, room, got), got)
+		return nil, nil
+	}
+	return world.Do([]DoFunc{getPlayer, getRoom, getThings, resolve})
+}
@@ -0,0 +1,106 @@
+/*
+target_test.go tests parsing targets, and resolving them among things.
+*/
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Target
+		ok   bool
+	}{
+		{"sword", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"sword"}}, true},
+		{"  Rusty SWORD ", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"rusty", "sword"}}, true},
+		{"rusty.sword", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"rusty", "sword"}}, true},
+		{"2.sword", Target{Ordinal: 2, Id: invalidIdentifier, Keywords: []string{"sword"}}, true},
+		{"12.rusty sword", Target{Ordinal: 12, Id: invalidIdentifier, Keywords: []string{"rusty", "sword"}}, true},
+		{"0.sword", Target{}, false},
+		{"-1.sword", Target{}, false},
+		{"2.", Target{}, false},
+		{"all", Target{All: true, Ordinal: 1, Id: invalidIdentifier}, true},
+		{"all.", Target{All: true, Ordinal: 1, Id: invalidIdentifier, Keywords: []string{}}, true},
+		{"all.coin", Target{All: true, Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"coin"}}, true},
+		{"ALL.gold coin", Target{All: true, Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"gold", "coin"}}, true},
+		{"allspice", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"allspice"}}, true},
+		{"x.sword", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"x", "sword"}}, true},
+		{".sword", Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"sword"}}, true},
+		{"42", Target{Ordinal: 1, Id: 42}, true},
+		{"", Target{}, false},
+		{"  ", Target{}, false},
+		{".", Target{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseTarget(test.s)
+		if ok != test.ok {
+			t.Errorf("ParseTarget(%q) ok %v, want %v", test.s, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", test.s, got, test.want)
+		}
+	}
+}
+
+// TestParseQuotedTarget checks targets typed in quotes, which the parser passes as one argument.
+func TestParseQuotedTarget(t *testing.T) {
+	tests := []struct {
+		line string
+		want Target
+	}{
+		{`"long sword"`, Target{Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"long", "sword"}}},
+		{`"2.long sword"`, Target{Ordinal: 2, Id: invalidIdentifier, Keywords: []string{"long", "sword"}}},
+		{`"all.gold coin"`, Target{All: true, Ordinal: 1, Id: invalidIdentifier, Keywords: []string{"gold", "coin"}}},
+	}
+	for _, test := range tests {
+		args := splitArgs(test.line)
+		if len(args) != 1 {
+			t.Errorf("%s: split into %q, want one argument", test.line, args)
+			continue
+		}
+		if got, ok := ParseTarget(args[0]); !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parsed %+v %v, want %+v", test.line, got, ok, test.want)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	got := Got{items: map[identifier]*Item{
+		1: {id: 1, name: "sword", keywords: "rusty iron"},
+		2: {id: 2, name: "coin", keywords: "gold"},
+		3: {id: 3, name: "sword", keywords: "long steel"},
+		4: {id: 4, name: "coin", keywords: "silver"},
+	}}
+	ids := []identifier{1, 2, 3, 4}
+	tests := []struct {
+		s    string
+		want []identifier
+	}{
+		{"sword", []identifier{1}},
+		{"2.sword", []identifier{3}},
+		{"3.sword", nil},
+		{"rus sw", []identifier{1}},
+		{"long sword", []identifier{3}},
+		{"iron steel", nil},
+		{"all.coin", []identifier{2, 4}},
+		{"all.gold", []identifier{2}},
+		{"all", []identifier{1, 2, 3, 4}},
+		{"all.", []identifier{1, 2, 3, 4}},
+		{"4", []identifier{4}},
+		{"5", nil},
+	}
+	for _, test := range tests {
+		target, ok := ParseTarget(test.s)
+		if !ok {
+			t.Errorf("%q didn't parse", test.s)
+			continue
+		}
+		if resolved := resolveAmong(target, ids, got); !reflect.DeepEqual(resolved, test.want) {
+			t.Errorf("%q resolved to %v, want %v", test.s, resolved, test.want)
+		}
+	}
+}
@@ -1,12 +1,24 @@
 /*
 telnet.go has telnet-related types and funcs.
 
-It negotiates telnet options, and handles telnet codes sent by clients
+It negotiates telnet options, and handles telnet codes sent by clients.
+
+Every connection is wrapped in a TelnetConn, which runs a telnet state machine (RFC 854, RFC 855)
+over the bytes read from the client. Commands and subnegotiations are stripped out, and only clean
+text is returned from Read, so getString and getBytesSecure never see telnet codes, even when
+a code straddles two reads or appears in the middle of a line.
+
+Option negotiation uses the Q method (RFC 1143), which prevents negotiation loops,
+and tracks the state of every option for both sides of the connection.
 */
 package main
 
 import (
+	"bytes"
+	"compress/zlib"
+	"io"
 	"net"
+	"sync"
 )
 
 //
@@ -33,42 +45,633 @@ const (
 	IAC  = 255
 )
 
-type telnet_state struct {
-	LocalEcho bool
+// telnet options
+const (
+	optEcho = 1
+	optSGA  = 3
+	optNAWS = 31
+)
+
+// maxSubnegotiation is the largest subnegotiation we will buffer, to prevent clients exhausting memory
+const maxSubnegotiation = 8192
+
+// telnetReadSize is the size of the buffer raw bytes are read into from the underlying connection
+const telnetReadSize = 512
+
+type telnetParseState int
+
+const (
+	tsData telnetParseState = iota
+	tsCR
+	tsIAC
+	tsWill
+	tsWont
+	tsDo
+	tsDont
+	tsSB
+	tsSBData
+	tsSBIAC
+)
+
+// qState is the state of one side of an option, per RFC 1143
+type qState byte
+
+const (
+	qNo qState = iota
+	qYes
+	qWantNo
+	qWantYes
+)
+
+// telnetOptionState is the Q method state of a single option.
+// us is the state of the option on our side, him is the state on the client's side.
+// The queue bools are true when the opposite request is queued.
+type telnetOptionState struct {
+	us       qState
+	usQueue  bool
+	him      qState
+	himQueue bool
+	allowUs  bool
+	allowHim bool
+	// refused is set when the client refuses our request to enable the option, so we don't keep asking
+	usRefused  bool
+	himRefused bool
 }
 
-func handleTelnet(recieved []byte, c net.Conn) []byte {
-	if len(recieved) == 0 {
-		return recieved
+// TelnetOptionFunc is called when an option is enabled or disabled.
+// local is true if the option changed on our side, false if it changed on the client's.
+type TelnetOptionFunc func(option byte, local bool, enabled bool)
+
+// TelnetSubnegotiationFunc is called with the data of a subnegotiation the client sent for an option.
+type TelnetSubnegotiationFunc func(option byte, data []byte)
+
+// TelnetConn is a net.Conn which strips and handles telnet codes from reads, and escapes writes.
+// Read returns at most a single line at a time. Text after the line is kept for the next Read.
+type TelnetConn struct {
+	net.Conn
+	raw     []byte
+	pending []byte
+
+	state    telnetParseState
+	sbOption byte
+	sbData   []byte
+
+	optionsMutex   sync.Mutex
+	options        [256]telnetOptionState
+	optionFuncs    map[byte][]TelnetOptionFunc
+	subnegotiators map[byte]TelnetSubnegotiationFunc
+
+	writeMutex sync.Mutex
+	out        io.Writer
+	compressor *zlib.Writer // non-nil while MCCP2 compression is on
+
+	// queue, if set, queues writes of telnet codes on the Session, so they're ordered with its text, and never block.
+	// control writes, such as negotiation, must not be dropped. Without a Session, telnet codes are written immediately.
+	// It has its own mutex, because writeMutex is held for as long as a stalled client takes to read.
+	queueMutex sync.Mutex
+	queue      func(write func() error, control bool) error
+
+	// window size sent by the client via NAWS. 0 if unknown.
+	width  int
+	height int
+
+	gmcp gmcpState
+	msdp msdpState
+}
+
+func NewTelnetConn(c net.Conn) *TelnetConn {
+	return &TelnetConn{
+		Conn:           c,
+		raw:            make([]byte, telnetReadSize),
+		out:            c,
+		optionFuncs:    make(map[byte][]TelnetOptionFunc),
+		subnegotiators: make(map[byte]TelnetSubnegotiationFunc),
 	}
-	if recieved[0] != IAC {
-		return recieved
+}
+
+// telnetOf returns the TelnetConn of the given connection, if it is one.
+func telnetOf(c net.Conn) (*TelnetConn, bool) {
+	for {
+		switch conn := c.(type) {
+		case *TelnetConn:
+			return conn, true
+		case interface {
+			Unwrap() net.Conn
+		}:
+			c = conn.Unwrap()
+		default:
+			return nil, false
+		}
 	}
-	recieved = recieved[1:]
-	var option byte
-	if len(recieved) > 1 {
-		option = recieved[0]
-		recieved = recieved[1:]
-	} else {
-		option = byte(0)
+}
+
+// Read reads clean text from the client, with all telnet codes handled and removed.
+func (t *TelnetConn) Read(p []byte) (int, error) {
+	for len(t.pending) == 0 {
+		n, err := t.Conn.Read(t.raw)
+		if n > 0 {
+			t.parse(t.raw[:n])
+			for i := range t.raw[:n] {
+				t.raw[i] = 0
+			}
+		}
+		if err != nil {
+			if len(t.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+
+	end := len(t.pending)
+	if newline := bytes.IndexByte(t.pending, '\n'); newline >= 0 {
+		end = newline + 1
+	}
+	n := copy(p, t.pending[:end])
+	remaining := copy(t.pending, t.pending[n:])
+	for i := remaining; i < len(t.pending); i++ {
+		t.pending[i] = 0 // this may be a password; don't leave it lying around
+	}
+	t.pending = t.pending[:remaining]
+	return n, nil
+}
+
+// Write escapes IAC bytes and writes the message to the client. Text should be written through the Session, which calls this.
+func (t *TelnetConn) Write(p []byte) (int, error) {
+	escaped := p
+	if bytes.IndexByte(p, IAC) >= 0 {
+		escaped = bytes.Replace(p, []byte{IAC}, []byte{IAC, IAC}, -1)
+	}
+	if _, err := t.writeNow(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close finishes the compressed stream, if any, and closes the connection.
+func (t *TelnetConn) Close() error {
+	t.stopCompression()
+	return t.Conn.Close()
+}
+
+// setQueue sets the func telnet codes are queued with. See TelnetConn.queue.
+func (t *TelnetConn) setQueue(queue func(write func() error, control bool) error) {
+	t.queueMutex.Lock()
+	defer t.queueMutex.Unlock()
+	t.queue = queue
+}
+
+func (t *TelnetConn) getQueue() func(write func() error, control bool) error {
+	t.queueMutex.Lock()
+	defer t.queueMutex.Unlock()
+	return t.queue
+}
+
+// writeNow writes bytes to the client unescaped, immediately.
+func (t *TelnetConn) writeNow(p []byte) (int, error) {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	return t.out.Write(p)
+}
+
+// writeRaw writes bytes to the client unescaped, through the queue if there is one. It's used for sending telnet codes.
+func (t *TelnetConn) writeRaw(p []byte, control bool) error {
+	queue := t.getQueue()
+	if queue == nil {
+		_, err := t.writeNow(p)
+		return err
 	}
-	// for now, reject all requests. We're very contrary.
-	if option == DO || option == WILL {
-		if option == DO {
-			c.Write([]byte{IAC, WONT, option})
-		} else {
-			c.Write([]byte{IAC, DONT, option})
+	return queue(func() error {
+		_, err := t.writeNow(p)
+		return err
+	}, control)
+}
+
+func (t *TelnetConn) sendCommand(command telnet_command, option byte) {
+	t.writeRaw(telnetCommandBytes(command, option), true)
+}
+
+// Subnegotiate sends a subnegotiation for the given option. IAC bytes in data are escaped.
+func (t *TelnetConn) Subnegotiate(option byte, data []byte) {
+	msg := make([]byte, 0, len(data)+5)
+	msg = append(msg, IAC, SB, option)
+	msg = append(msg, bytes.Replace(data, []byte{IAC}, []byte{IAC, IAC}, -1)...)
+	msg = append(msg, IAC, SE)
+	t.writeRaw(msg, false)
+}
+
+// parse runs the telnet state machine over raw bytes from the client.
+// Text is appended to pending, and commands are handled.
+// State is kept between calls, so codes split across reads are handled correctly.
+func (t *TelnetConn) parse(raw []byte) {
+	for _, b := range raw {
+		switch t.state {
+		case tsData:
+			switch b {
+			case IAC:
+				t.state = tsIAC
+			case '\r':
+				t.pending = append(t.pending, b)
+				t.state = tsCR
+			case 0:
+			default:
+				t.pending = append(t.pending, b)
+			}
+		case tsCR:
+			// RFC 854: CR must be followed by LF or NUL. CR NUL is a bare carriage return.
+			t.state = tsData
+			switch b {
+			case IAC:
+				t.state = tsIAC
+			case 0:
+			case '\r':
+				t.pending = append(t.pending, b)
+				t.state = tsCR
+			default:
+				t.pending = append(t.pending, b)
+			}
+		case tsIAC:
+			t.state = tsData
+			switch b {
+			case IAC:
+				t.pending = append(t.pending, IAC)
+			case WILL:
+				t.state = tsWill
+			case WONT:
+				t.state = tsWont
+			case DO:
+				t.state = tsDo
+			case DONT:
+				t.state = tsDont
+			case SB:
+				t.state = tsSB
+			case AYT:
+				t.writeRaw([]byte(endl+"[gomud is here]"+endl), false)
+			case EC:
+				if len(t.pending) > 0 && t.pending[len(t.pending)-1] != '\n' {
+					t.pending[len(t.pending)-1] = 0
+					t.pending = t.pending[:len(t.pending)-1]
+				}
+			case EL:
+				lineStart := bytes.LastIndexByte(t.pending, '\n') + 1
+				for i := lineStart; i < len(t.pending); i++ {
+					t.pending[i] = 0
+				}
+				t.pending = t.pending[:lineStart]
+			default:
+				// NOP, DM, BRK, IP, AO, GA and unknown commands are ignored
+			}
+		case tsWill:
+			t.state = tsData
+			t.receiveWill(b)
+		case tsWont:
+			t.state = tsData
+			t.receiveWont(b)
+		case tsDo:
+			t.state = tsData
+			t.receiveDo(b)
+		case tsDont:
+			t.state = tsData
+			t.receiveDont(b)
+		case tsSB:
+			t.sbOption = b
+			t.sbData = t.sbData[:0]
+			t.state = tsSBData
+		case tsSBData:
+			if b == IAC {
+				t.state = tsSBIAC
+			} else if len(t.sbData) < maxSubnegotiation {
+				t.sbData = append(t.sbData, b)
+			}
+		case tsSBIAC:
+			switch b {
+			case IAC:
+				if len(t.sbData) < maxSubnegotiation {
+					t.sbData = append(t.sbData, IAC)
+				}
+				t.state = tsSBData
+			case SE:
+				t.state = tsData
+				t.subnegotiation(t.sbOption, t.sbData)
+			default:
+				// RFC 855 requires IAC SE; treat anything else as an aborted subnegotiation
+				t.state = tsData
+			}
 		}
 	}
-	return recieved
-	/*
-		var optionInfo byte
-		if len(readBuf) > 2 {
-			optionInfo = readBuf[2]
-		} else {
-			optionInfo = byte(0)
+}
+
+func (t *TelnetConn) subnegotiation(option byte, data []byte) {
+	t.optionsMutex.Lock()
+	f, ok := t.subnegotiators[option]
+	t.optionsMutex.Unlock()
+	if !ok {
+		return
+	}
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	f(option, dataCopy)
+}
+
+// telnetChange is an option state change, which is reported to the option funcs after the options are unlocked.
+type telnetChange struct {
+	option  byte
+	local   bool
+	enabled bool
+}
+
+// changeOptions locks the options, calls change, and then calls the option funcs for every resulting change.
+// Option funcs are called after unlocking, so they may negotiate further.
+func (t *TelnetConn) changeOptions(change func() []telnetChange) {
+	t.optionsMutex.Lock()
+	changes := change()
+	var funcs [][]TelnetOptionFunc
+	for _, c := range changes {
+		funcs = append(funcs, t.optionFuncs[c.option])
+	}
+	t.optionsMutex.Unlock()
+	for i, c := range changes {
+		for _, f := range funcs[i] {
+			f(c.option, c.local, c.enabled)
+		}
+	}
+}
+
+// receiveWill handles the client offering to enable an option on its side.
+func (t *TelnetConn) receiveWill(option byte) {
+	t.changeOptions(func() []telnetChange {
+		o := &t.options[option]
+		switch o.him {
+		case qNo:
+			if !o.allowHim {
+				t.sendCommand(DONT, option)
+				return nil
+			}
+			o.him = qYes
+			t.sendCommand(DO, option)
+			return []telnetChange{{option, false, true}}
+		case qWantNo:
+			if !o.himQueue {
+				// DONT answered by WILL; the client is broken. Treat as refused.
+				o.him = qNo
+				return nil
+			}
+			o.him = qYes
+			o.himQueue = false
+			return []telnetChange{{option, false, true}}
+		case qWantYes:
+			if !o.himQueue {
+				o.him = qYes
+				return []telnetChange{{option, false, true}}
+			}
+			o.him = qWantNo
+			o.himQueue = false
+			t.sendCommand(DONT, option)
+		}
+		return nil
+	})
+}
+
+// receiveWont handles the client refusing or disabling an option on its side.
+func (t *TelnetConn) receiveWont(option byte) {
+	t.changeOptions(func() []telnetChange {
+		o := &t.options[option]
+		switch o.him {
+		case qYes:
+			o.him = qNo
+			t.sendCommand(DONT, option)
+			return []telnetChange{{option, false, false}}
+		case qWantNo:
+			if !o.himQueue {
+				o.him = qNo
+				return []telnetChange{{option, false, false}}
+			}
+			o.him = qWantYes
+			o.himQueue = false
+			t.sendCommand(DO, option)
+		case qWantYes:
+			o.him = qNo
+			o.himQueue = false
+			o.himRefused = true
+			return []telnetChange{{option, false, false}}
+		}
+		return nil
+	})
+}
+
+// receiveDo handles the client asking us to enable an option on our side.
+func (t *TelnetConn) receiveDo(option byte) {
+	t.changeOptions(func() []telnetChange {
+		o := &t.options[option]
+		switch o.us {
+		case qNo:
+			if !o.allowUs {
+				t.sendCommand(WONT, option)
+				return nil
+			}
+			o.us = qYes
+			t.sendCommand(WILL, option)
+			return []telnetChange{{option, true, true}}
+		case qWantNo:
+			if !o.usQueue {
+				o.us = qNo
+				return nil
+			}
+			o.us = qYes
+			o.usQueue = false
+			return []telnetChange{{option, true, true}}
+		case qWantYes:
+			if !o.usQueue {
+				o.us = qYes
+				return []telnetChange{{option, true, true}}
+			}
+			o.us = qWantNo
+			o.usQueue = false
+			t.sendCommand(WONT, option)
+		}
+		return nil
+	})
+}
+
+// receiveDont handles the client refusing an option, or asking us to disable it.
+func (t *TelnetConn) receiveDont(option byte) {
+	t.changeOptions(func() []telnetChange {
+		o := &t.options[option]
+		switch o.us {
+		case qYes:
+			o.us = qNo
+			t.sendCommand(WONT, option)
+			return []telnetChange{{option, true, false}}
+		case qWantNo:
+			if !o.usQueue {
+				o.us = qNo
+				return []telnetChange{{option, true, false}}
+			}
+			o.us = qWantYes
+			o.usQueue = false
+			t.sendCommand(WILL, option)
+		case qWantYes:
+			o.us = qNo
+			o.usQueue = false
+			o.usRefused = true
+			return []telnetChange{{option, true, false}}
+		}
+		return nil
+	})
+}
+
+// request asks for an option to be enabled or disabled on one side, per the RFC 1143 Q method.
+func (t *TelnetConn) request(option byte, local bool, enable bool) {
+	t.changeOptions(func() []telnetChange {
+		o := &t.options[option]
+		state, queue := &o.him, &o.himQueue
+		yes, no := telnet_command(DO), telnet_command(DONT)
+		if local {
+			state, queue = &o.us, &o.usQueue
+			yes, no = WILL, WONT
 		}
-	*/
+		switch {
+		case enable && *state == qNo:
+			*state = qWantYes
+			t.sendCommand(yes, option)
+		case enable && *state == qWantNo:
+			*queue = true
+		case enable && *state == qWantYes:
+			*queue = false
+		case !enable && *state == qYes:
+			*state = qWantNo
+			t.sendCommand(no, option)
+		case !enable && *state == qWantYes:
+			*queue = true
+		case !enable && *state == qWantNo:
+			*queue = false
+		}
+		return nil
+	})
+}
+
+// EnableLocal asks the client to let us enable the option on our side (IAC WILL).
+func (t *TelnetConn) EnableLocal(option byte) {
+	t.request(option, true, true)
+}
+
+// DisableLocal disables the option on our side (IAC WONT).
+func (t *TelnetConn) DisableLocal(option byte) {
+	t.request(option, true, false)
+}
+
+// EnableRemote asks the client to enable the option on its side (IAC DO).
+func (t *TelnetConn) EnableRemote(option byte) {
+	t.request(option, false, true)
+}
+
+// DisableRemote asks the client to disable the option on its side (IAC DONT).
+func (t *TelnetConn) DisableRemote(option byte) {
+	t.request(option, false, false)
+}
+
+// SupportLocal sets whether we agree when the client asks us to enable the option (IAC DO).
+func (t *TelnetConn) SupportLocal(option byte, allow bool) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	t.options[option].allowUs = allow
+}
+
+// SupportRemote sets whether we agree when the client offers to enable the option (IAC WILL).
+func (t *TelnetConn) SupportRemote(option byte, allow bool) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	t.options[option].allowHim = allow
+}
+
+// Local returns whether the option is enabled on our side.
+func (t *TelnetConn) Local(option byte) bool {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.options[option].us == qYes
+}
+
+// Remote returns whether the option is enabled on the client's side.
+func (t *TelnetConn) Remote(option byte) bool {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.options[option].him == qYes
+}
+
+// LocalRefused returns whether the client has refused to let us enable the option on our side.
+func (t *TelnetConn) LocalRefused(option byte) bool {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.options[option].usRefused
+}
+
+// RemoteRefused returns whether the client has refused to enable the option on its side.
+func (t *TelnetConn) RemoteRefused(option byte) bool {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.options[option].himRefused
+}
+
+// OnOption registers a func to be called when the given option is enabled or disabled, on either side.
+func (t *TelnetConn) OnOption(option byte, f TelnetOptionFunc) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	t.optionFuncs[option] = append(t.optionFuncs[option], f)
+}
+
+// OnSubnegotiation sets the func called with subnegotiations the client sends for the given option.
+func (t *TelnetConn) OnSubnegotiation(option byte, f TelnetSubnegotiationFunc) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	t.subnegotiators[option] = f
+}
+
+// hideInput asks the client to stop echoing what the player types, for passwords. Web clients are told with setWebInputHidden.
+// We claim the echo (IAC WILL ECHO) and then don't echo. Clients which refuse
+// keep echoing locally, and aren't asked again.
+func hideInput(c net.Conn) {
+	if setWebInputHidden(c, true) {
+		return
+	}
+	t, ok := telnetOf(c)
+	if !ok || t.LocalRefused(optEcho) {
+		return
+	}
+	t.EnableLocal(optEcho)
+}
+
+// showInput gives the echo back to the client (IAC WONT ECHO) after hideInput.
+func showInput(c net.Conn) {
+	if setWebInputHidden(c, false) {
+		return
+	}
+	t, ok := telnetOf(c)
+	if !ok {
+		return
+	}
+	hidden := t.Local(optEcho)
+	t.DisableLocal(optEcho) // also cancels a request the client hasn't answered yet
+	if hidden {
+		c.Write([]byte(endl)) // the client didn't echo the player's newline
+	}
+}
+
+// WindowSize returns the width and height of the client's window, or 0 if the client hasn't sent them.
+func (t *TelnetConn) WindowSize() (int, int) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	return t.width, t.height
+}
+
+// handleNAWS handles the client's window size, IAC SB NAWS width(2 bytes) height(2 bytes) IAC SE, per RFC 1073.
+func (t *TelnetConn) handleNAWS(option byte, data []byte) {
+	if len(data) != 4 {
+		return
+	}
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+	t.width = int(data[0])<<8 | int(data[1])
+	t.height = int(data[2])<<8 | int(data[3])
 }
 
 func telnetCommandBytes(command telnet_command, option byte) []byte {
@@ -79,7 +682,27 @@ func telnetCommandBytes(command telnet_command, option byte) []byte {
 	return commandBytes
 }
 
-func negotiateTelnet(c net.Conn) {
-	//	fmt.Println("sending" + string(telnetCommandBytes(WILL, 1)))
-	//	c.Write(telnetCommandBytes(DO, 1)) // IAC WILL ECHO
+// supportTelnetOptions sets which options we support, and their handlers.
+func supportTelnetOptions(t *TelnetConn, world *World) {
+	t.SupportRemote(optNAWS, true)
+	t.OnSubnegotiation(optNAWS, t.handleNAWS)
+	t.SupportLocal(optMCCP2, true)
+	t.OnOption(optMCCP2, t.handleMCCP2)
+	t.SupportLocal(optGMCP, true)
+	t.OnSubnegotiation(optGMCP, t.handleGMCP)
+	t.SupportLocal(optMSDP, true)
+	t.OnSubnegotiation(optMSDP, t.handleMSDP)
+	supportMSSP(t, world)
+}
+
+// negotiateTelnet wraps a new connection in a TelnetConn, and asks the client for the options we support.
+func negotiateTelnet(c net.Conn, world *World) *TelnetConn {
+	t := NewTelnetConn(c)
+	supportTelnetOptions(t, world)
+	t.EnableRemote(optNAWS)
+	t.EnableLocal(optMCCP2)
+	t.EnableLocal(optGMCP)
+	t.EnableLocal(optMSDP)
+	t.EnableLocal(optMSSP)
+	return t
 }
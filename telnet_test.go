@@ -0,0 +1,235 @@
+/*
+telnet_test.go tests the telnet state machine: stripping codes from reads, escaping writes, and Q method negotiation.
+*/
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// chunkConn is a net.Conn which returns each chunk from a separate Read, and records what's written.
+type chunkConn struct {
+	net.Conn
+	chunks  [][]byte
+	written bytes.Buffer
+}
+
+func (c *chunkConn) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	if c.chunks[0] = c.chunks[0][n:]; len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func (c *chunkConn) Write(p []byte) (int, error) {
+	return c.written.Write(p)
+}
+
+func (c *chunkConn) Close() error {
+	return nil
+}
+
+func newTestTelnet(chunks ...[]byte) (*TelnetConn, *chunkConn) {
+	c := &chunkConn{chunks: chunks}
+	return NewTelnetConn(c), c
+}
+
+// readAll reads clean text from the TelnetConn until the chunks run out.
+func readAll(t *TelnetConn) string {
+	var text []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := t.Read(buf)
+		text = append(text, buf[:n]...)
+		if err != nil {
+			return string(text)
+		}
+	}
+}
+
+func TestTelnetReadStripsCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   string
+	}{
+		{"plain", [][]byte{[]byte("look\r\n")}, "look\r\n"},
+		{"command mid-line", [][]byte{{'l', 'o', IAC, NOP, 'o', 'k', '\r', '\n'}}, "look\r\n"},
+		{"escaped IAC", [][]byte{{'a', IAC, IAC, 'b', '\n'}}, "a\xffb\n"},
+		{"escaped IAC split", [][]byte{{'a', IAC}, {IAC, 'b', '\n'}}, "a\xffb\n"},
+		{"command split", [][]byte{{'a', IAC}, {GA, 'b', '\n'}}, "ab\n"},
+		{"CR NUL", [][]byte{{'a', '\r', 0, 'b', '\n'}}, "a\rb\n"},
+		{"NUL stripped", [][]byte{{'a', 0, 'b', '\n'}}, "ab\n"},
+		{"erase character", [][]byte{{'a', 'b', IAC, EC, 'c', '\n'}}, "ac\n"},
+		{"erase line", [][]byte{[]byte("one\n"), {'t', 'w', 'o', IAC, EL, 'x', '\n'}}, "one\nx\n"},
+		{"subnegotiation stripped", [][]byte{{'a', IAC, SB, 99, 1, 2, IAC, SE, 'b', '\n'}}, "ab\n"},
+		{"subnegotiation split", [][]byte{{'a', IAC, SB}, {99, 1}, {2, IAC}, {SE, 'b', '\n'}}, "ab\n"},
+		{"aborted subnegotiation", [][]byte{{'a', IAC, SB, 99, 1, IAC, NOP, 'b', '\n'}}, "ab\n"},
+	}
+	for _, test := range tests {
+		tc, _ := newTestTelnet(test.chunks...)
+		if got := readAll(tc); got != test.want {
+			t.Errorf("%s: read %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTelnetReadOneLineAtATime(t *testing.T) {
+	tc, _ := newTestTelnet([]byte("one\r\ntwo\r\n"))
+	buf := make([]byte, 64)
+	for _, want := range []string{"one\r\n", "two\r\n"} {
+		n, err := tc.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Errorf("read %q %v, want %q", buf[:n], err, want)
+		}
+	}
+}
+
+func TestTelnetWriteEscapesIAC(t *testing.T) {
+	tc, c := newTestTelnet()
+	n, err := tc.Write([]byte{'a', IAC, 'b'})
+	if err != nil || n != 3 {
+		t.Errorf("Write returned %d %v, want 3 nil", n, err)
+	}
+	if want := []byte{'a', IAC, IAC, 'b'}; !bytes.Equal(c.written.Bytes(), want) {
+		t.Errorf("wrote %v, want %v", c.written.Bytes(), want)
+	}
+}
+
+func TestTelnetSubnegotiateEscapesIAC(t *testing.T) {
+	tc, c := newTestTelnet()
+	tc.Subnegotiate(optGMCP, []byte{'x', IAC})
+	if want := []byte{IAC, SB, optGMCP, 'x', IAC, IAC, IAC, SE}; !bytes.Equal(c.written.Bytes(), want) {
+		t.Errorf("wrote %v, want %v", c.written.Bytes(), want)
+	}
+}
+
+func TestTelnetNAWS(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        [][]byte
+		width, height int
+	}{
+		{"whole", [][]byte{{IAC, SB, optNAWS, 0, 80, 0, 24, IAC, SE}}, 80, 24},
+		{"split", [][]byte{{IAC, SB, optNAWS, 0}, {80, 0}, {24, IAC}, {SE}}, 80, 24},
+		{"escaped 255", [][]byte{{IAC, SB, optNAWS, 0, IAC, IAC, 0, 24, IAC, SE}}, 255, 24},
+		{"wrong length", [][]byte{{IAC, SB, optNAWS, 0, 80, 0, IAC, SE}}, 0, 0},
+	}
+	for _, test := range tests {
+		tc, _ := newTestTelnet(test.chunks...)
+		tc.OnSubnegotiation(optNAWS, tc.handleNAWS)
+		readAll(tc)
+		if width, height := tc.WindowSize(); width != test.width || height != test.height {
+			t.Errorf("%s: window %dx%d, want %dx%d", test.name, width, height, test.width, test.height)
+		}
+	}
+}
+
+// expectWritten checks what the TelnetConn wrote since the last check, and forgets it.
+func expectWritten(t *testing.T, step string, c *chunkConn, want []byte) {
+	if !bytes.Equal(c.written.Bytes(), want) {
+		t.Errorf("%s: wrote %v, want %v", step, c.written.Bytes(), want)
+	}
+	c.written.Reset()
+}
+
+func TestTelnetRefusesUnsupportedOptions(t *testing.T) {
+	tc, c := newTestTelnet()
+	tc.parse([]byte{IAC, DO, optEcho})
+	expectWritten(t, "DO unsupported", c, []byte{IAC, WONT, optEcho})
+	tc.parse([]byte{IAC, WILL, optNAWS})
+	expectWritten(t, "WILL unsupported", c, []byte{IAC, DONT, optNAWS})
+	if tc.Local(optEcho) || tc.Remote(optNAWS) {
+		t.Errorf("unsupported options were enabled")
+	}
+}
+
+func TestTelnetAcceptsSupportedOptions(t *testing.T) {
+	tc, c := newTestTelnet()
+	tc.SupportRemote(optNAWS, true)
+	var changes []telnetChange
+	tc.OnOption(optNAWS, func(option byte, local bool, enabled bool) {
+		changes = append(changes, telnetChange{option, local, enabled})
+	})
+	tc.parse([]byte{IAC, WILL, optNAWS})
+	expectWritten(t, "WILL", c, []byte{IAC, DO, optNAWS})
+	if !tc.Remote(optNAWS) {
+		t.Errorf("WILL didn't enable the option")
+	}
+	tc.parse([]byte{IAC, WILL, optNAWS})
+	expectWritten(t, "WILL again", c, nil) // already enabled, so answering would loop
+	tc.parse([]byte{IAC, WONT, optNAWS})
+	expectWritten(t, "WONT", c, []byte{IAC, DONT, optNAWS})
+	if tc.Remote(optNAWS) {
+		t.Errorf("WONT didn't disable the option")
+	}
+	want := []telnetChange{{optNAWS, false, true}, {optNAWS, false, false}}
+	if len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] {
+		t.Errorf("option changes %v, want %v", changes, want)
+	}
+}
+
+func TestTelnetQMethod(t *testing.T) {
+	tc, c := newTestTelnet()
+	tc.SupportLocal(optEcho, true)
+
+	tc.EnableLocal(optEcho)
+	expectWritten(t, "enable", c, []byte{IAC, WILL, optEcho})
+	tc.EnableLocal(optEcho)
+	expectWritten(t, "enable while asking", c, nil)
+	tc.parse([]byte{IAC, DO, optEcho})
+	expectWritten(t, "DO answering WILL", c, nil)
+	if !tc.Local(optEcho) {
+		t.Fatalf("DO didn't enable the option")
+	}
+
+	tc.DisableLocal(optEcho)
+	expectWritten(t, "disable", c, []byte{IAC, WONT, optEcho})
+	tc.EnableLocal(optEcho) // queued until the client answers
+	expectWritten(t, "enable while disabling", c, nil)
+	tc.parse([]byte{IAC, DONT, optEcho})
+	expectWritten(t, "DONT answering WONT, with enable queued", c, []byte{IAC, WILL, optEcho})
+	if tc.Local(optEcho) {
+		t.Errorf("option enabled before the client agreed")
+	}
+	tc.parse([]byte{IAC, DO, optEcho})
+	expectWritten(t, "DO answering queued WILL", c, nil)
+	if !tc.Local(optEcho) {
+		t.Errorf("DO didn't enable the option")
+	}
+
+	tc.DisableLocal(optEcho)
+	tc.parse([]byte{IAC, DONT, optEcho})
+	c.written.Reset()
+	tc.EnableLocal(optEcho)
+	tc.DisableLocal(optEcho) // queued until the client answers
+	expectWritten(t, "enable then disable", c, []byte{IAC, WILL, optEcho})
+	tc.parse([]byte{IAC, DO, optEcho})
+	expectWritten(t, "DO with disable queued", c, []byte{IAC, WONT, optEcho})
+	tc.parse([]byte{IAC, DONT, optEcho})
+	expectWritten(t, "DONT answering WONT", c, nil)
+	if tc.Local(optEcho) {
+		t.Errorf("option enabled after it was disabled")
+	}
+}
+
+func TestTelnetRefusal(t *testing.T) {
+	tc, c := newTestTelnet()
+	tc.EnableRemote(optNAWS)
+	expectWritten(t, "enable", c, []byte{IAC, DO, optNAWS})
+	tc.parse([]byte{IAC, WONT, optNAWS})
+	expectWritten(t, "WONT answering DO", c, nil)
+	if tc.Remote(optNAWS) || !tc.RemoteRefused(optNAWS) {
+		t.Errorf("refusal wasn't recorded")
+	}
+	if tc.LocalRefused(optNAWS) {
+		t.Errorf("refusal was recorded on the wrong side")
+	}
+}
@@ -42,6 +42,12 @@ type Thing interface {
 	SetId(id identifier)
 }
 
+// Snapshotter is a Thing whose changes are published to clients; see ThingManager.Changes.
+// Snapshot returns a copy of the Thing, including its maps, so the publisher can read it while the next setter changes the Thing.
+type Snapshotter interface {
+	Snapshot() Thing
+}
+
 type actualThing struct {
 	id   identifier
 	name string
@@ -116,10 +122,24 @@ type ThingAdderMsg struct {
 	response chan identifier
 }
 
+type saverOpType int
+
+const (
+	saveAdd saverOpType = iota
+	saveChange
+	saveDel
+)
+
+// saverOp is an add, change or delete for the saver to write to the database.
+type saverOp struct {
+	op    saverOpType
+	thing Thing      // for adds and changes
+	id    identifier // for deletes
+}
+
 type ThingSaver struct {
-	add    chan Thing
-	del    chan identifier
-	change chan Thing
+	ops   chan saverOp // in the order they happened, so e.g. a change is never saved after a later delete
+	flush chan chan bool
 }
 
 type ThingManager struct {
@@ -130,7 +150,16 @@ type ThingManager struct {
 	add               chan ThingAdderMsg
 	dbAdd             chan Thing
 	del               chan identifier
+	unload            chan identifier
+	count             chan chan int
 	saver             ThingSaver
+	changes           chan Thing
+}
+
+// Changes returns a chan which receives a snapshot of every Snapshotter Thing after it's changed.
+// It has a single reader, which publishes changes to clients. If it isn't read fast enough, changes are dropped.
+func (m ThingManager) Changes() <-chan Thing {
+	return m.changes
 }
 
 func (m ThingManager) GetThingAccessor(id identifier) ThingAccessor {
@@ -171,6 +200,25 @@ func (m ThingManager) Remove(id identifier) {
 	m.del <- id
 }
 
+// Unload removes the Thing from the manager, without deleting it from the database.
+func (m ThingManager) Unload(id identifier) {
+	m.unload <- id
+}
+
+// Flush waits until the saver has saved every add, change and delete sent to it so far.
+func (m ThingManager) Flush() {
+	done := make(chan bool)
+	m.saver.flush <- done
+	<-done
+}
+
+// Count returns the number of Things in the manager.
+func (m ThingManager) Count() int {
+	response := make(chan int)
+	m.count <- response
+	return <-response
+}
+
 func (a ThingAccessor) TryGet(chainTime ChainTime) (setter SetterMsg, ok bool, reset bool) {
 	if a.ThingSetter == nil || a.ThingGetter == nil {
 		return SetterMsg{}, false, false
@@ -217,11 +265,13 @@ func NewThingManager() *ThingManager {
 		add:               make(chan ThingAdderMsg),
 		dbAdd:             make(chan Thing),
 		del:               make(chan identifier),
+		unload:            make(chan identifier),
+		count:             make(chan chan int),
 		saver: ThingSaver{
-			add:    make(chan Thing, 1000),
-			del:    make(chan identifier, 1000),
-			change: make(chan Thing, 1000),
+			ops:   make(chan saverOp, 3000),
+			flush: make(chan chan bool),
 		},
+		changes: make(chan Thing, 1000),
 	}
 	go func() {
 		type thingAccessors struct {
@@ -260,14 +310,36 @@ func NewThingManager() *ThingManager {
 			}
 			var settingFunc func(thing Thing, thingChan chan Thing, time ChainTime)
 			settingFunc = func(thing Thing, thingChan chan Thing, time ChainTime) {
+				// closing is set if the Thing is unloaded or removed while it's being set, so the manager never waits for the setter.
+				// The Thing closes when it's released, and its final value is saved if it was unloaded, but not if it was removed.
+				closing := false
+				saveClosing := false
 				for {
 					select {
 					case t := <-thingChan:
+						if closing {
+							if saveClosing {
+								manager.saver.ops <- saverOp{op: saveChange, thing: t}
+							}
+							close(getter)
+							close(setter)
+							return
+						}
+						if snapshotter, ok := t.(Snapshotter); ok {
+							// snapshot before the Thing is released to the next setter
+							select {
+							case manager.changes <- snapshotter.Snapshot():
+							default:
+							}
+						}
 						go thingFunc(t, settingFunc)
-						manager.saver.change <- t
+						manager.saver.ops <- saverOp{op: saveChange, thing: t}
 						return
 					case getter <- thing:
 					case setTimeGetter <- time:
+					case save := <-closer:
+						closing = true
+						saveClosing = save
 					}
 				}
 			}
@@ -283,15 +355,23 @@ func NewThingManager() *ThingManager {
 				addThing.thing.SetId(<-NextId)
 				doAdd(addThing.thing)
 				addThing.response <- addThing.thing.Id()
-				manager.saver.add <- addThing.thing
+				manager.saver.ops <- saverOp{op: saveAdd, thing: addThing.thing}
 			case thing := <-manager.dbAdd:
 				doAdd(thing)
 			case d := <-manager.del:
+				Things[d].closer <- false
+				delete(ThingsByName, ThingNameMap[d])
+				delete(Things, d)
+				delete(ThingNameMap, d)
+				manager.saver.ops <- saverOp{op: saveDel, id: d}
+			case d := <-manager.unload:
+				if _, ok := Things[d]; !ok {
+					continue
+				}
 				Things[d].closer <- true
 				delete(ThingsByName, ThingNameMap[d])
 				delete(Things, d)
 				delete(ThingNameMap, d)
-				manager.saver.del <- d
 			case g := <-manager.getAccessor:
 				g.response <- ThingAccessor{Things[g.id].getter, Things[g.id].setter, Things[g.id].setTimeGetter}
 			case g := <-manager.getAccessorByName:
@@ -300,6 +380,8 @@ func NewThingManager() *ThingManager {
 				g.response <- Things[g.id].getter
 			case s := <-manager.getSetter:
 				s.response <- Things[s.id].setter
+			case c := <-manager.count:
+				c <- len(Things)
 			}
 		}
 	}()
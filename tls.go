@@ -0,0 +1,147 @@
+/*
+tls.go runs an optional TLS listener, so telnet (and passwords) can be encrypted.
+
+Connections are telnet over TLS, and go through the same login pipeline as plain telnet.
+
+The certificate and key are loaded from -tlscert and -tlskey. If they aren't configured,
+a self-signed certificate is generated at startup. If they're configured but neither exists,
+the generated certificate is saved there, so clients can pin it across restarts.
+If only one exists, TLS is disabled, so e.g. a mistyped key path never overwrites a real certificate.
+*/
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// tlsHandshakeTimeout is how long a client may take to finish the TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// generateCertificate creates a self-signed certificate, and returns it and its key, PEM encoded.
+func generateCertificate() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gomud"}, CommonName: hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{hostname, "localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPem, keyPem, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadCertificate loads the configured certificate, or generates a self-signed one if neither file is configured or exists.
+// If only one of the files is configured or exists, it returns an error, rather than overwriting the other with a generated one.
+func loadCertificate(certFile string, keyFile string) (tls.Certificate, error) {
+	if (certFile == "") != (keyFile == "") {
+		return tls.Certificate{}, errors.New("-tlscert and -tlskey must be given together")
+	}
+	configured := certFile != ""
+	if configured {
+		certExists, keyExists := fileExists(certFile), fileExists(keyFile)
+		if certExists && keyExists {
+			return tls.LoadX509KeyPair(certFile, keyFile)
+		}
+		if certExists {
+			return tls.Certificate{}, errors.New("TLS certificate " + certFile + " exists, but its key " + keyFile + " doesn't")
+		}
+		if keyExists {
+			return tls.Certificate{}, errors.New("TLS key " + keyFile + " exists, but its certificate " + certFile + " doesn't")
+		}
+	}
+
+	fmt.Println("generating a self-signed TLS certificate")
+	certPem, keyPem, err := generateCertificate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if configured {
+		if err := ioutil.WriteFile(certFile, certPem, 0644); err != nil {
+			fmt.Println("error saving TLS certificate: " + err.Error())
+		}
+		if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+			fmt.Println("error saving TLS key: " + err.Error())
+		}
+	}
+	return tls.X509KeyPair(certPem, keyPem)
+}
+
+// tlsHandshake finishes the TLS handshake, if the connection is TLS, so a client which never sends it can't block anything.
+func tlsHandshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	return tlsConn.SetDeadline(time.Time{})
+}
+
+// listenTLS accepts telnet over TLS.
+func listenTLS(world World, config Config) {
+	cert, err := loadCertificate(config.TLSCert, config.TLSKey)
+	if err != nil {
+		fmt.Println("TLS error: " + err.Error())
+		return
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ln, err := tls.Listen("tcp", ":"+strconv.Itoa(config.TLSPort), tlsConfig)
+	if err != nil {
+		fmt.Println("TLS error: " + err.Error())
+		return
+	}
+	addListener(ln)
+	fmt.Println("TLS running at " + ln.Addr().String())
+	acceptTelnet(world, config, ln)
+}
@@ -0,0 +1,45 @@
+/*
+tls_test.go tests loading, generating and saving the TLS certificate.
+*/
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if _, err := loadCertificate("", ""); err != nil {
+		t.Fatalf("generating an unsaved certificate: %v", err)
+	}
+	if _, err := loadCertificate(certFile, ""); err == nil {
+		t.Errorf("a certificate without a key was accepted")
+	}
+
+	if _, err := loadCertificate(certFile, keyFile); err != nil {
+		t.Fatalf("generating a saved certificate: %v", err)
+	}
+	if !fileExists(certFile) || !fileExists(keyFile) {
+		t.Fatalf("the generated certificate wasn't saved")
+	}
+	certPem, _ := ioutil.ReadFile(certFile)
+	if _, err := loadCertificate(certFile, keyFile); err != nil {
+		t.Fatalf("loading the saved certificate: %v", err)
+	}
+
+	if _, err := loadCertificate(certFile, filepath.Join(dir, "mistyped.pem")); err == nil {
+		t.Errorf("a certificate whose key is missing was accepted")
+	}
+	if after, _ := ioutil.ReadFile(certFile); !bytes.Equal(after, certPem) {
+		t.Errorf("the certificate was overwritten")
+	}
+	if _, err := loadCertificate(filepath.Join(dir, "mistyped.pem"), keyFile); err == nil {
+		t.Errorf("a key whose certificate is missing was accepted")
+	}
+}
@@ -0,0 +1,453 @@
+/*
+websocket.go lets browsers play, with a WebSocket gateway (RFC 6455) and a small web terminal.
+
+listenWeb serves the terminal page at / and upgrades /ws to a WebSocket.
+The upgraded connection is a WebSocketConn, which is a net.Conn, so it goes through
+the same handleLogin and handlePlayer flow as telnet. Each text message from the browser
+is a line of input, and everything written is sent to the browser as a text message.
+Browser clients don't speak telnet, so they aren't wrapped in a TelnetConn.
+Instead of telnet's echo negotiation, the web client is told to hide and show the player's input, e.g. for passwords,
+with binary messages, which text written to the player can't fake.
+
+The web client is off unless -webport is given. Any WebSocket client can connect to /ws, e.g. for scripted testing:
+
+	gomud -webport 9242
+	websocat ws://localhost:9242/ws
+
+Browsers may only open websockets from the web client's own page, or from origins given with -weborigins,
+so other sites can't use a player's browser to connect.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage is the largest message we accept from a browser, to prevent clients exhausting memory
+const maxWebSocketMessage = 65536
+
+// the binary messages which tell the web client to hide and show the player's input
+const (
+	webInputHide = "hide input"
+	webInputShow = "show input"
+)
+
+// maxWebSocketControl is the largest control frame payload allowed, per RFC 6455 section 5.5
+const maxWebSocketControl = 125
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocketConn is a net.Conn which reads and writes WebSocket messages.
+// Like TelnetConn, Read returns at most a single line at a time.
+type WebSocketConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	pending    []byte
+	message    []byte // a fragmented message being received
+	fragmented bool   // whether a fragmented message has been started, and not finished
+
+	writeMutex sync.Mutex
+	closed     bool
+}
+
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// originAllowed returns whether the request's origin may open a websocket.
+// Browsers always send an Origin. Other clients, such as scripts, may not, and can't be used by other sites, so they're allowed.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowedOrigin := range allowed {
+		if strings.EqualFold(origin, allowedOrigin) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the WebSocket handshake, and returns the hijacked connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*WebSocketConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || !headerContains(r.Header, "Upgrade", "websocket") || !headerContains(r.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "expected a websocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if !originAllowed(r, allowedOrigins) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, errors.New("websocket origin not allowed: " + r.Header.Get("Origin"))
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version " + r.Header.Get("Sec-WebSocket-Version"))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets unsupported", http.StatusInternalServerError)
+		return nil, errors.New("http.ResponseWriter is not a Hijacker")
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocketConn{Conn: conn, reader: buffer.Reader}, nil
+}
+
+// readFrame reads a single frame, and returns its opcode, whether it's the final fragment, and its unmasked payload.
+func (ws *WebSocketConn) readFrame() (byte, bool, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return 0, false, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if !masked {
+		return 0, false, nil, errors.New("websocket client frame not masked")
+	}
+	if length > maxWebSocketMessage {
+		return 0, false, nil, errors.New("websocket frame too large: " + strconv.FormatUint(length, 10))
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, false, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, false, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, fin, payload, nil
+}
+
+// writeFrame writes a single, final, unmasked frame.
+func (ws *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	if ws.closed {
+		return errors.New("websocket closed")
+	}
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	if _, err := ws.Conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == wsClose {
+		ws.closed = true
+	}
+	return nil
+}
+
+// readMessage reads frames until a complete text or binary message, handling control frames as they come.
+func (ws *WebSocketConn) readMessage() ([]byte, error) {
+	for {
+		opcode, fin, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if opcode&0x8 != 0 && (!fin || len(payload) > maxWebSocketControl) {
+			return nil, errors.New("websocket control frame fragmented or too large")
+		}
+		switch opcode {
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if ws.fragmented {
+				return nil, errors.New("websocket message started before the last was finished")
+			}
+			ws.message = ws.message[:0]
+		case wsContinuation:
+			if !ws.fragmented {
+				return nil, errors.New("websocket continuation without a message")
+			}
+		default:
+			return nil, errors.New("websocket unknown opcode " + strconv.Itoa(int(opcode)))
+		}
+		if len(ws.message)+len(payload) > maxWebSocketMessage {
+			return nil, errors.New("websocket message too large")
+		}
+		ws.message = append(ws.message, payload...)
+		ws.fragmented = !fin
+		if fin {
+			message := ws.message
+			ws.message = nil
+			return message, nil
+		}
+	}
+}
+
+// Read reads lines sent by the browser. Each message is a line; a newline is added if the browser didn't send one.
+func (ws *WebSocketConn) Read(p []byte) (int, error) {
+	for len(ws.pending) == 0 {
+		message, err := ws.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		ws.pending = append(ws.pending, message...)
+		if !bytes.HasSuffix(ws.pending, []byte("\n")) {
+			ws.pending = append(ws.pending, '\r', '\n')
+		}
+	}
+	end := len(ws.pending)
+	if newline := bytes.IndexByte(ws.pending, '\n'); newline >= 0 {
+		end = newline + 1
+	}
+	n := copy(p, ws.pending[:end])
+	remaining := copy(ws.pending, ws.pending[n:])
+	for i := remaining; i < len(ws.pending); i++ {
+		ws.pending[i] = 0 // this may be a password; don't leave it lying around
+	}
+	ws.pending = ws.pending[:remaining]
+	return n, nil
+}
+
+// Write sends p to the browser as a single text message.
+func (ws *WebSocketConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsText, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame, and closes the connection.
+func (ws *WebSocketConn) Close() error {
+	ws.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000, normal closure
+	return ws.Conn.Close()
+}
+
+// webSocketOf returns the WebSocketConn which c is, or wraps.
+func webSocketOf(c net.Conn) (*WebSocketConn, bool) {
+	for {
+		switch conn := c.(type) {
+		case *WebSocketConn:
+			return conn, true
+		case interface {
+			Unwrap() net.Conn
+		}:
+			c = conn.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// setWebInputHidden tells a web client to hide or show what the player types. It returns false if c isn't a web client.
+// The message is queued on the session, so the client gets it in order with the text around it, such as a password prompt.
+func setWebInputHidden(c net.Conn, hidden bool) bool {
+	ws, ok := webSocketOf(c)
+	if !ok {
+		return false
+	}
+	command := []byte(webInputShow)
+	if hidden {
+		command = []byte(webInputHide)
+	}
+	write := func() error { return ws.writeFrame(wsBinary, command) }
+	if s, ok := sessionOf(c); ok {
+		s.queueControl(write, true)
+		return true
+	}
+	write()
+	return true
+}
+
+// listenWeb serves the web terminal and the websocket gateway.
+func listenWeb(world World, config Config) {
+	port := config.WebPort
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(webClientPage))
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		waitWhilePaused()
+		conn, err := upgradeWebSocket(w, r, config.WebOrigins)
+		if err != nil {
+			fmt.Println("websocket error: " + err.Error())
+			return
+		}
+		handleConnection(world, config, conn)
+	})
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		fmt.Println("web error: " + err.Error())
+		return
+	}
+	addListener(ln)
+	fmt.Println("web client running at " + ln.Addr().String())
+	err = http.Serve(ln, mux)
+	if err != nil && !listenersClosed() {
+		fmt.Println("web error: " + err.Error())
+	}
+}
+
+// webClientPage is a minimal browser terminal. It converts ANSI colors to HTML, and sends a line per message.
+const webClientPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gomud</title>
+<style>
+body { margin: 0; background: #000; color: #ccc; font-family: monospace; display: flex; flex-direction: column; height: 100vh; }
+#output { flex: 1; overflow-y: auto; white-space: pre-wrap; word-wrap: break-word; padding: 0.5em; }
+#input { border: none; border-top: 1px solid #444; background: #111; color: #eee; font-family: monospace; font-size: 1em; padding: 0.5em; }
+</style>
+</head>
+<body>
+<div id="output"></div>
+<input id="input" type="text" autocomplete="off" autofocus>
+<script>
+var colors = {30: "#000", 31: "#a00", 32: "#0a0", 33: "#a50", 34: "#00a", 35: "#a0a", 36: "#0aa", 37: "#aaa"};
+var bright = {30: "#555", 31: "#f55", 32: "#5f5", 33: "#ff5", 34: "#55f", 35: "#f5f", 36: "#5ff", 37: "#fff"};
+var output = document.getElementById("output");
+var input = document.getElementById("input");
+var color = null;
+
+function escapeHtml(s) {
+	return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+}
+
+function print(text) {
+	var html = "";
+	var parts = text.replace(/\r/g, "").split(/\x1b\[([0-9;]*)m/);
+	for (var i = 0; i < parts.length; i++) {
+		if (i % 2 == 1) {
+			var codes = parts[i].split(";");
+			var isBright = codes.indexOf("1") >= 0;
+			color = null;
+			for (var j = 0; j < codes.length; j++) {
+				var c = parseInt(codes[j], 10);
+				if (c >= 30 && c <= 37) {
+					color = isBright ? bright[c] : colors[c];
+				}
+			}
+			continue;
+		}
+		if (parts[i] == "") {
+			continue;
+		}
+		html += color ? "<span style=\"color:" + color + "\">" + escapeHtml(parts[i]) + "</span>" : escapeHtml(parts[i]);
+	}
+	var atBottom = output.scrollTop + output.clientHeight >= output.scrollHeight - 4;
+	output.insertAdjacentHTML("beforeend", html);
+	if (atBottom) {
+		output.scrollTop = output.scrollHeight;
+	}
+}
+
+var scheme = location.protocol == "https:" ? "wss://" : "ws://";
+var socket = new WebSocket(scheme + location.host + "/ws");
+socket.binaryType = "arraybuffer";
+socket.onmessage = function(e) {
+	if (typeof e.data == "string") {
+		print(e.data);
+		return;
+	}
+	// binary messages are commands from the server, not text
+	var command = new TextDecoder().decode(e.data);
+	if (command == "hide input") {
+		input.type = "password";
+	} else if (command == "show input") {
+		input.type = "text";
+	}
+};
+socket.onclose = function() { print("\n[connection closed]\n"); };
+input.addEventListener("keydown", function(e) {
+	if (e.key != "Enter") {
+		return;
+	}
+	socket.send(input.value);
+	if (input.type != "password") {
+		print(input.value + "\n");
+	}
+	input.value = "";
+});
+</script>
+</body>
+</html>
+`
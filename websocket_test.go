@@ -0,0 +1,267 @@
+/*
+websocket_test.go tests the WebSocket gateway: the handshake, origin checks, and reading and writing frames.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientFrame returns a masked frame, as a client sends them.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// readServerFrame reads an unmasked frame, as the server sends them, and returns its opcode and payload.
+func readServerFrame(r io.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		return 0, nil, errors.New("server frame not final, or masked")
+	}
+	length := int(header[1])
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(r, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// the example from RFC 6455 section 1.3
+	if got, want := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("websocketAccept returned %q, want %q", got, want)
+	}
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	serverErr := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgradeWebSocket(w, r, nil)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer ws.Close()
+		line := make([]byte, 64)
+		n, err := ws.Read(line)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		_, err = ws.Write([]byte("echo " + string(line[:n])))
+		serverErr <- err
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := "GET /ws HTTP/1.1\r\n" +
+		"Host: " + server.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Origin: " + server.URL + "\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got, want := response.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("Sec-WebSocket-Accept %q, want %q", got, want)
+	}
+
+	if _, err := conn.Write(clientFrame(true, wsText, []byte("look"))); err != nil {
+		t.Fatal(err)
+	}
+	opcode, payload, err := readServerFrame(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != wsText || string(payload) != "echo look\r\n" {
+		t.Errorf("read frame %d %q, want %d %q", opcode, payload, wsText, "echo look\r\n")
+	}
+	if err := <-serverErr; err != nil {
+		t.Errorf("server error: %v", err)
+	}
+	if opcode, _, err := readServerFrame(reader); err != nil || opcode != wsClose {
+		t.Errorf("read frame %d %v, want close", opcode, err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://mud.example.com", true},
+		{"https://MUD.example.com", true},
+		{"https://friend.example.com", true},
+		{"https://evil.example.com", false},
+		{"http://mud.example.com.evil.example.com", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://mud.example.com/ws", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := originAllowed(r, []string{"https://friend.example.com"}); got != test.want {
+			t.Errorf("origin %q allowed %v, want %v", test.origin, got, test.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "http://mud.example.com/ws", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	if _, err := upgradeWebSocket(w, r, nil); err == nil || w.Code != http.StatusForbidden {
+		t.Errorf("upgrade from another origin returned %d %v, want %d", w.Code, err, http.StatusForbidden)
+	}
+}
+
+// pipeWebSocket returns a WebSocketConn, and the client end of its connection.
+func pipeWebSocket() (*WebSocketConn, net.Conn) {
+	server, client := net.Pipe()
+	return &WebSocketConn{Conn: server, reader: bufio.NewReader(server)}, client
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	ws, client := pipeWebSocket()
+	defer client.Close()
+	clientErr := make(chan error, 1)
+	go func() {
+		if _, err := client.Write(clientFrame(true, wsPing, []byte("ping"))); err != nil {
+			clientErr <- err
+			return
+		}
+		opcode, payload, err := readServerFrame(client)
+		if err == nil && (opcode != wsPong || string(payload) != "ping") {
+			err = errors.New("ping not answered with its pong")
+		}
+		if err != nil {
+			clientErr <- err
+			return
+		}
+		// a ping between the fragments of a message is allowed
+		for _, frame := range [][]byte{
+			clientFrame(false, wsText, []byte("lo")),
+			clientFrame(true, wsPong, nil),
+			clientFrame(true, wsContinuation, []byte("ok")),
+		} {
+			if _, err := client.Write(frame); err != nil {
+				clientErr <- err
+				return
+			}
+		}
+		clientErr <- nil
+	}()
+	line := make([]byte, 64)
+	n, err := ws.Read(line)
+	if err != nil || string(line[:n]) != "look\r\n" {
+		t.Errorf("read %q %v, want %q", line[:n], err, "look\r\n")
+	}
+	if err := <-clientErr; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebSocketRejectsBadFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"control frame too large", [][]byte{clientFrame(true, wsPing, bytes.Repeat([]byte{'x'}, maxWebSocketControl+1))}},
+		{"fragmented control frame", [][]byte{clientFrame(false, wsPing, []byte("pi")), clientFrame(true, wsContinuation, []byte("ng"))}},
+		{"continuation without a message", [][]byte{clientFrame(true, wsContinuation, []byte("look"))}},
+		{"message inside a message", [][]byte{clientFrame(false, wsText, []byte("lo")), clientFrame(true, wsText, []byte("ok"))}},
+		{"unmasked", [][]byte{{0x80 | wsText, 4, 'l', 'o', 'o', 'k'}}},
+	}
+	for _, test := range tests {
+		ws, client := pipeWebSocket()
+		go func(frames [][]byte) {
+			for _, frame := range frames {
+				if _, err := client.Write(frame); err != nil {
+					return
+				}
+			}
+		}(test.frames)
+		line := make([]byte, 256)
+		if n, err := ws.Read(line); err == nil {
+			t.Errorf("%s: read %q, want an error", test.name, line[:n])
+		} else if strings.Contains(err.Error(), "closed pipe") {
+			t.Errorf("%s: read error %v, want a protocol error", test.name, err)
+		}
+		client.Close()
+	}
+}
+
+// TestWebSocketHiddenInput checks the web client is told to hide and show input with binary messages, in order with the text.
+func TestWebSocketHiddenInput(t *testing.T) {
+	ws, client := pipeWebSocket()
+	defer client.Close()
+	s := NewSession(ws, Config{OutputQueue: 10})
+	defer s.Close()
+	hideInput(s)
+	s.Write([]byte("Please enter your password.\r\n"))
+	showInput(s)
+	want := []struct {
+		opcode  byte
+		payload string
+	}{
+		{wsBinary, webInputHide},
+		{wsText, "Please enter your password.\r\n"},
+		{wsBinary, webInputShow},
+	}
+	for _, w := range want {
+		opcode, payload, err := readServerFrame(client)
+		if err != nil || opcode != w.opcode || string(payload) != w.payload {
+			t.Fatalf("read frame %x %q %v, want %x %q", opcode, payload, err, w.opcode, w.payload)
+		}
+	}
+}
@@ -11,6 +11,8 @@ type World struct {
 	items   *ItemManager
 	npcs    *NpcManager
 	db      *sql.DB
+	config  Config
+	owner   identifier // the account of the -owner character; see findOwnerAccount
 }
 
 type ToGet struct {